@@ -1,7 +1,33 @@
 package main
 
-import migrator "github.com/launchdarkly-labs/context-migration/migrator"
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	migrator "github.com/launchdarkly-labs/context-migration/migrator"
+)
 
 func main() {
-	migrator.Migrate()
+	command := strings.Join(os.Args[1:], " ")
+
+	switch command {
+	case "":
+		migrator.Migrate()
+	case "attributes":
+		migrator.AttributesReport()
+	case "prerequisites":
+		migrator.PrerequisitesReport()
+	case "sdks":
+		migrator.SDKReport()
+	case "legacy-users":
+		migrator.LegacyUsersReport()
+	case "schema lint":
+		migrator.LintSchema()
+	case "schema suggest":
+		migrator.SuggestSchema()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command '%v'.\n", command)
+		os.Exit(1)
+	}
 }
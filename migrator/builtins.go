@@ -0,0 +1,129 @@
+package migrator
+
+import (
+	"fmt"
+	"sort"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+// Legacy user attributes that had built-in behavior in the user model, and how they behave in the contexts model
+var legacyBuiltins = map[string]string{
+	"secondary": "The 'secondary' attribute doesn't exist in the contexts model. It no longer affects bucketing in percentage rollouts, and clauses that use it never match.",
+	"anonymous": "The 'anonymous' attribute is a property of each context in the contexts model rather than a user attribute.",
+	"name":      "The 'name' attribute is built into every context kind in the contexts model.",
+	"ip":        "The 'ip' attribute is an ordinary attribute in the contexts model.",
+	"country":   "The 'country' attribute is an ordinary attribute in the contexts model.",
+	"email":     "The 'email' attribute is an ordinary attribute in the contexts model.",
+	"firstName": "The 'firstName' attribute is an ordinary attribute in the contexts model.",
+	"lastName":  "The 'lastName' attribute is an ordinary attribute in the contexts model.",
+	"avatar":    "The 'avatar' attribute is an ordinary attribute in the contexts model.",
+}
+
+// Legacy built-ins whose clauses behave differently after the migration, so they're always called out
+var changedBuiltins = []string{"secondary", "anonymous"}
+
+// Helper function to find a legacy built-in attribute however it's written, so that a reference such as `/secondary`
+// is recognized as well as `secondary`. Returns the built-in's name and its note.
+func legacyBuiltin(attribute string) (string, string, bool) {
+	if note, isBuiltin := legacyBuiltins[attribute]; isBuiltin {
+		return attribute, note, true
+	}
+
+	canonical := canonicalAttribute(attribute)
+	for name, note := range legacyBuiltins {
+		if canonicalAttribute(name) == canonical {
+			return name, note, true
+		}
+	}
+	return "", "", false
+}
+
+// Helper function to propose a mapping for a legacy built-in attribute that the schema doesn't map. Built-ins
+// follow the user key: when the key moves to another context kind, the built-in moves to the same attribute of
+// that kind. The 'secondary' attribute has no equivalent, so it's never proposed.
+func proposedBuiltinMapping(attribute string) (attributeSchema, bool) {
+	name, _, isBuiltin := legacyBuiltin(attribute)
+	if !isBuiltin || name == "secondary" {
+		return attributeSchema{}, false
+	}
+	if _, isMapped := lookupMapping(schema, name); isMapped {
+		return attributeSchema{}, false
+	}
+
+	keyMapping, isMapped := lookupMapping(schema, keyAttribute)
+	if !isMapped || keyMapping.Kind == userKind {
+		return attributeSchema{}, false
+	}
+	return attributeSchema{Kind: keyMapping.Kind, Attribute: name}, true
+}
+
+// Report what changes for a rule clause that uses a legacy built-in attribute
+func reportBuiltinClause(attribute string, isMapped bool) {
+	if name, note, isBuiltin := legacyBuiltin(attribute); isBuiltin && contains(changedBuiltins, name) {
+		fmt.Printf("  Warning: %v\n", note)
+	}
+
+	if !isMapped {
+		if proposed, isProposed := proposedBuiltinMapping(attribute); isProposed {
+			fmt.Printf("  Consider mapping the legacy built-in attribute '%v' to '%v' attribute '%v'.\n", attribute, proposed.Kind, proposed.Attribute)
+		}
+	}
+}
+
+// Returns true if the flag's user targeting, or the schema that applies to the flag, refers to the 'secondary'
+// attribute. Only then is it worth noting that its rollouts may bucket some contexts differently.
+func referencesSecondary(flag ldapi.FeatureFlag, details flagDetails) bool {
+	if _, isMapped := baseMappingForFlag(flag, "secondary"); isMapped {
+		return true
+	}
+
+	isSecondary := func(rollout *ldapi.Rollout) bool {
+		return rollout != nil && rollout.BucketBy != nil && sameAttribute(*rollout.BucketBy, "secondary")
+	}
+	for _, rule := range details.ruleUserRefs {
+		for _, clause := range rule.clauses {
+			if sameAttribute(clause.Attribute, "secondary") {
+				return true
+			}
+		}
+		if isSecondary(rule.rollout) {
+			return true
+		}
+	}
+	return isSecondary(details.fallthroughRollout)
+}
+
+// Helper function to get the schema warnings about legacy built-in attributes
+func builtinWarnings(s map[string]attributeSchema) []string {
+	warnings := []string{}
+	if _, isMapped := lookupMapping(s, "secondary"); isMapped {
+		warnings = append(warnings, legacyBuiltins["secondary"]+" Its mapping only affects rule clauses.")
+	}
+	return warnings
+}
+
+// Print a schema snippet with the proposed mappings for legacy built-in attributes that the schema doesn't map
+func printProposedBuiltinMappings() {
+	attributes := make([]string, 0, len(legacyBuiltins))
+	for attribute := range legacyBuiltins {
+		attributes = append(attributes, attribute)
+	}
+	sort.Strings(attributes)
+
+	proposals := []string{}
+	for _, attribute := range attributes {
+		if proposed, isProposed := proposedBuiltinMapping(attribute); isProposed {
+			proposals = append(proposals, fmt.Sprintf("%v:\n  kind: %v\n  attribute: %v\n", attribute, proposed.Kind, proposed.Attribute))
+		}
+	}
+
+	if len(proposals) > 0 {
+		fmt.Println("The schema doesn't map these legacy built-in attributes. Consider adding these mappings:")
+		fmt.Println()
+		for _, proposal := range proposals {
+			fmt.Print(proposal)
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,86 @@
+package migrator
+
+import (
+	"testing"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+func TestReferencesSecondary(t *testing.T) {
+	secondary := "secondary"
+	userClause := func(attribute string) ldapi.Clause {
+		return ldapi.Clause{Attribute: attribute, Op: "in", ContextKind: strPtr(userKind)}
+	}
+
+	tests := []struct {
+		name    string
+		schema  map[string]attributeSchema
+		details flagDetails
+		want    bool
+	}{
+		{"rollout without secondary", nil, flagDetails{fallthroughRollout: &ldapi.Rollout{}}, false},
+		{"clause on secondary", nil, flagDetails{ruleUserRefs: []ruleInfo{{clauses: []ldapi.Clause{userClause("secondary")}}}}, true},
+		{"clause on another attribute", nil, flagDetails{ruleUserRefs: []ruleInfo{{clauses: []ldapi.Clause{userClause("email")}}}}, false},
+		{"rollout by secondary", nil, flagDetails{fallthroughRollout: &ldapi.Rollout{BucketBy: &secondary}}, true},
+		{"schema maps secondary", map[string]attributeSchema{"secondary": {Kind: "device", Attribute: "id"}}, flagDetails{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useSchema(t, tt.schema)
+			if got := referencesSecondary(ldapi.FeatureFlag{Key: "flag"}, tt.details); got != tt.want {
+				t.Errorf("referencesSecondary() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLegacyBuiltin(t *testing.T) {
+	tests := []struct {
+		attribute string
+		wantName  string
+		want      bool
+	}{
+		{"secondary", "secondary", true},
+		{"/secondary", "secondary", true},
+		{"/anonymous", "anonymous", true},
+		{"/address/country", "", false},
+		{"plan", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.attribute, func(t *testing.T) {
+			name, note, isBuiltin := legacyBuiltin(tt.attribute)
+			if name != tt.wantName || isBuiltin != tt.want || (isBuiltin && note == "") {
+				t.Errorf("legacyBuiltin() = %v, %q, %v, want %v, %v", name, note, isBuiltin, tt.wantName, tt.want)
+			}
+		})
+	}
+}
+
+func TestProposedBuiltinMapping(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{keyAttribute: {Kind: "account", Attribute: keyAttribute}})
+
+	tests := []struct {
+		attribute string
+		want      attributeSchema
+		wantFound bool
+	}{
+		{"/email", attributeSchema{Kind: "account", Attribute: "email"}, true},
+		{"/secondary", attributeSchema{}, false},
+		{"plan", attributeSchema{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.attribute, func(t *testing.T) {
+			got, found := proposedBuiltinMapping(tt.attribute)
+			if found != tt.wantFound || got.Kind != tt.want.Kind || got.Attribute != tt.want.Attribute {
+				t.Errorf("proposedBuiltinMapping() = %v, %v, want %v, %v", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,355 @@
+package migrator
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	maxScannedFileSize = 1 << 20 // Larger files are skipped, since they're almost never source code
+	binarySniffLength  = 8000    // A file with a NUL byte this close to its start is treated as binary
+)
+
+// The delimiters that surround flag keys in code unless a repository's code references configuration changes them,
+// as in ld-find-code-refs
+var defaultDelimiters = []string{`"`, `'`, "`"}
+
+// The characters that flag keys can be made of. Delimited strings made of anything else can't be flag keys.
+var flagKeyToken = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// A repository that's checked out on disk, named the same way as in code references
+type localRepository struct {
+	name string
+	path string
+}
+
+// The delimited strings found in each local repository, keyed by repository name
+var (
+	localCodeRefs    map[string]map[string]bool
+	localCodeRefsErr error
+)
+
+// Parse a LOCAL_REPOSITORIES entry, which is a path or a name and a path separated by '='. Without a name, the
+// repository is named after its directory.
+func parseLocalRepository(entry string) (localRepository, error) {
+	name, path := "", entry
+	if i := strings.Index(entry, "="); i >= 0 {
+		name, path = entry[:i], entry[i+1:]
+	}
+
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return localRepository{}, err
+	}
+	if name == "" {
+		name = filepath.Base(absolute)
+	}
+	return localRepository{name, absolute}, nil
+}
+
+// Exits unless LOCAL_REPOSITORIES was supplied. Commands that scan local repositories must call this first.
+func requireLocalRepositories() {
+	if len(localRepos) == 0 {
+		log.Fatal("Must supply LOCAL_REPOSITORIES")
+		os.Exit(13)
+	}
+}
+
+// Walk the files of a local repository, skipping the .git directory, ignored paths, and large or binary files. Paths
+// passed to visit are relative to the repository and use forward slashes.
+func walkRepository(repo localRepository, visit func(relPath string, content []byte) error) error {
+	ignored, err := readIgnoreFiles(repo.path, "")
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(repo.path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(repo.path, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if entry.IsDir() {
+			if entry.Name() == ".git" || ignored.matches(relPath, true) {
+				return filepath.SkipDir
+			}
+			// The directory's own ignore files apply below it, and take precedence over the ones above it
+			nested, err := readIgnoreFiles(repo.path, relPath)
+			ignored = append(ignored, nested...)
+			return err
+		}
+		if !entry.Type().IsRegular() || ignored.matches(relPath, false) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxScannedFileSize {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sniff := content
+		if len(sniff) > binarySniffLength {
+			sniff = sniff[:binarySniffLength]
+		}
+		if bytes.IndexByte(sniff, 0) >= 0 {
+			return nil
+		}
+		return visit(relPath, content)
+	})
+}
+
+// Scan every local repository once for the delimited strings that could be flag keys
+func getLocalCodeRefs() (map[string]map[string]bool, error) {
+	if localCodeRefs != nil || localCodeRefsErr != nil {
+		return localCodeRefs, localCodeRefsErr
+	}
+
+	scanned := map[string]map[string]bool{}
+	for _, repo := range localRepos {
+		delimiters, err := readDelimiters(repo.path)
+		if err != nil {
+			localCodeRefsErr = fmt.Errorf("couldn't read the code references configuration of '%v': %v", repo.name, err)
+			return nil, localCodeRefsErr
+		}
+
+		tokens := map[string]bool{}
+		files := 0
+		err = walkRepository(repo, func(relPath string, content []byte) error {
+			files++
+			for _, token := range delimitedStrings(string(content), delimiters) {
+				tokens[token] = true
+			}
+			return nil
+		})
+		if err != nil {
+			localCodeRefsErr = fmt.Errorf("couldn't scan local repository '%v': %v", repo.name, err)
+			return nil, localCodeRefsErr
+		}
+
+		scanned[repo.name] = tokens
+		fmt.Printf("Scanned %v file(s) in local repository '%v' for flag keys.\n", files, repo.name)
+	}
+
+	localCodeRefs = scanned
+	return localCodeRefs, nil
+}
+
+// Helper function to get the strings that sit between two delimiters and could be flag keys
+func delimitedStrings(content string, delimiters []string) []string {
+	pairs := []string{}
+	for _, delimiter := range delimiters {
+		pairs = append(pairs, delimiter, "\x00")
+	}
+	pieces := strings.Split(strings.NewReplacer(pairs...).Replace(content), "\x00")
+
+	// The first and last pieces only have a delimiter on one side
+	tokens := []string{}
+	for i := 1; i < len(pieces)-1; i++ {
+		if flagKeyToken.MatchString(pieces[i]) {
+			tokens = append(tokens, pieces[i])
+		}
+	}
+	return tokens
+}
+
+// Read the delimiters from the repository's .launchdarkly/coderefs.yaml, which uses the same format as
+// ld-find-code-refs. Other settings in the file are ignored.
+func readDelimiters(repoPath string) ([]string, error) {
+	file, err := ioutil.ReadFile(filepath.Join(repoPath, ".launchdarkly", "coderefs.yaml"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return defaultDelimiters, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Delimiters struct {
+			DisableDefaults bool `yaml:"disableDefaults"`
+			Additional      []string
+		}
+	}
+	if err := yaml.Unmarshal(file, &config); err != nil {
+		return nil, err
+	}
+
+	delimiters := []string{}
+	if !config.Delimiters.DisableDefaults {
+		delimiters = append(delimiters, defaultDelimiters...)
+	}
+	for _, delimiter := range config.Delimiters.Additional {
+		if delimiter != "" {
+			delimiters = append(delimiters, delimiter)
+		}
+	}
+	if len(delimiters) == 0 {
+		return nil, fmt.Errorf("the defaults are disabled and no additional delimiters are given")
+	}
+	return delimiters, nil
+}
+
+// A pattern from an ignore file, which uses the .gitignore syntax
+type ignorePattern struct {
+	base    string // The directory of the ignore file, relative to the repository root
+	pattern *regexp.Regexp
+	negated bool
+	dirOnly bool
+}
+
+type ignorePatterns []ignorePattern
+
+// Read the .gitignore and .ldignore files in a directory of a repository, given relative to the repository root
+func readIgnoreFiles(repoPath string, dir string) (ignorePatterns, error) {
+	patterns := ignorePatterns{}
+	for _, name := range []string{".gitignore", ".ldignore"} {
+		file, err := os.Open(filepath.Join(repoPath, filepath.FromSlash(dir), name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if pattern, ok := parseIgnorePattern(scanner.Text()); ok {
+				pattern.base = dir
+				patterns = append(patterns, pattern)
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return patterns, nil
+}
+
+func parseIgnorePattern(line string) (ignorePattern, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+
+	pattern := ignorePattern{}
+	if strings.HasPrefix(line, "!") {
+		pattern.negated = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// A pattern with a slash before its end is relative to the repository root; otherwise it matches at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignorePattern{}, false
+	}
+
+	expr := globToRegexp(line)
+	if !anchored {
+		expr = "(.*/)?" + expr
+	}
+	compiled, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return ignorePattern{}, false
+	}
+	pattern.pattern = compiled
+	return pattern, true
+}
+
+// Helper function to convert a .gitignore glob to a regular expression, where `**` can match across directories
+func globToRegexp(glob string) string {
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			expr.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			if end := strings.IndexByte(glob[i+1:], ']'); end >= 0 {
+				class := glob[i+1 : i+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				expr.WriteString("[" + class + "]")
+				i += end + 1
+			} else {
+				expr.WriteString(`\[`)
+			}
+		case c == '\\' && i+1 < len(glob):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
+
+// Returns true if the last pattern that matches the path ignores it. Patterns from a nested ignore file only match
+// paths below its directory, relative to that directory.
+func (p ignorePatterns) matches(relPath string, isDir bool) bool {
+	ignored := false
+	for _, pattern := range p {
+		path := relPath
+		if pattern.base != "" {
+			if !strings.HasPrefix(relPath, pattern.base+"/") {
+				continue
+			}
+			path = relPath[len(pattern.base)+1:]
+		}
+		if (!pattern.dirOnly || isDir) && pattern.pattern.MatchString(path) {
+			ignored = !pattern.negated
+		}
+	}
+	return ignored
+}
+
+// Helper function to get the names of the local repositories that refer to a flag key. The repositories that
+// REPOSITORIES=auto skips because they don't depend on an SDK are left out.
+func localReposReferencing(flagKey string) ([]string, error) {
+	codeRefs, err := getLocalCodeRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, repo := range localRepos {
+		if codeRefs[repo.name][flagKey] && !contains(reposWithoutSDK, repo.name) {
+			names = append(names, repo.name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,155 @@
+package migrator
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob string
+		want string
+	}{
+		{"*.log", `[^/]*\.log`},
+		{"file?.txt", `file[^/]\.txt`},
+		{"**/build", `(.*/)?build`},
+		{"logs/**", `logs/.*`},
+		{"a/**/b", `a/(.*/)?b`},
+		{"[abc].go", `[abc]\.go`},
+		{"[!abc].go", `[^abc]\.go`},
+		{"[abc", `\[abc`},
+		{`\*.go`, `\*\.go`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.glob, func(t *testing.T) {
+			if got := globToRegexp(tt.glob); got != tt.want {
+				t.Errorf("globToRegexp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIgnorePattern(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment", "/", "!/"} {
+		if _, ok := parseIgnorePattern(line); ok {
+			t.Errorf("parseIgnorePattern(%q) = ok, want it skipped", line)
+		}
+	}
+
+	pattern, ok := parseIgnorePattern("!build/ ")
+	if !ok || !pattern.negated || !pattern.dirOnly || pattern.pattern.String() != "^(.*/)?build$" {
+		t.Errorf("parseIgnorePattern(%q) = %+v, %v", "!build/ ", pattern, ok)
+	}
+}
+
+func TestIgnorePatternsMatch(t *testing.T) {
+	patterns := ignorePatterns{}
+	for _, line := range []string{"*.log", "!keep.log", "/dist", "build/", "docs/*.md", "**/tmp/**"} {
+		pattern, _ := parseIgnorePattern(line)
+		patterns = append(patterns, pattern)
+	}
+	nested, _ := parseIgnorePattern("generated.go")
+	nested.base = "api"
+	patterns = append(patterns, nested)
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"app.log", false, true},
+		{"src/app.log", false, true},
+		{"keep.log", false, false},
+		{"dist", true, true},
+		{"src/dist", true, false},
+		{"build", true, true},
+		{"src/build", true, true},
+		{"build", false, false},
+		{"docs/readme.md", false, true},
+		{"docs/api/readme.md", false, false},
+		{"src/tmp/cache.json", false, true},
+		{"api/generated.go", false, true},
+		{"api/v1/generated.go", false, true},
+		{"web/generated.go", false, false},
+		{"apis/generated.go", false, false},
+		{"main.go", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := patterns.matches(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("matches(%v, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelimitedStrings(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		delimiters []string
+		want       []string
+	}{
+		{"double quotes", `ldClient.variation("new-checkout", user, false)`, defaultDelimiters, []string{"new-checkout"}},
+		{"mixed quotes", "flags = ['a-flag', `b.flag`]", defaultDelimiters, []string{"a-flag", "b.flag"}},
+		{"not flag keys", `print("hello world")`, defaultDelimiters, []string{}},
+		{"unclosed", `"a-flag`, defaultDelimiters, []string{}},
+		{"custom delimiter", "<<a-flag>>", []string{"<<", ">>"}, []string{"a-flag"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := delimitedStrings(tt.content, tt.delimiters); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("delimitedStrings() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWalkRepositoryNestedIgnoreFiles(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".gitignore":            "*.log\nvendor/\n",
+		"main.go":               "package main",
+		"app.log":               "log",
+		"vendor/lib.go":         "package lib",
+		"api/.gitignore":        "generated.go\n!keep.log\n",
+		"api/generated.go":      "package api",
+		"api/handler.go":        "package api",
+		"api/keep.log":          "log",
+		"api/v1/generated.go":   "package v1",
+		"web/.ldignore":         "/fixtures\n",
+		"web/fixtures/a.js":     "a",
+		"web/src/fixtures/b.js": "b",
+		"web/generated.go":      "package web",
+	}
+	for path, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	visited := []string{}
+	err := walkRepository(localRepository{"repo", root}, func(relPath string, content []byte) error {
+		visited = append(visited, relPath)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walkRepository() error = %v", err)
+	}
+
+	sort.Strings(visited)
+	want := []string{".gitignore", "api/.gitignore", "api/handler.go", "api/keep.log", "main.go", "web/.ldignore", "web/generated.go", "web/src/fixtures/b.js"}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("walkRepository() visited %v, want %v", visited, want)
+	}
+}
@@ -0,0 +1,249 @@
+package migrator
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	contextSampleSize = 1000 // The most contexts that are sampled from LaunchDarkly
+	contextPageSize   = 50
+)
+
+// An attribute seen in sample contexts of one kind
+type observedAttribute struct {
+	count  int
+	values map[string]bool
+}
+
+// The context kinds and attributes seen in a set of sample contexts. Attributes are keyed by their canonical form.
+type observedContexts struct {
+	total      int
+	kinds      map[string]int
+	attributes map[string]map[string]*observedAttribute
+}
+
+// The attribute names that were seen for each context kind in recent contexts. When the names come from
+// LaunchDarkly, only top-level names are known, so nested attribute references are checked by their first component.
+type recentAttributes struct {
+	attributes map[string]map[string]bool
+	nested     bool
+}
+
+var (
+	recent    *recentAttributes
+	recentErr error
+)
+
+// Load the attribute names of recent contexts once, from CONTEXTS_FILE or from LaunchDarkly
+func getRecentAttributes() (*recentAttributes, error) {
+	if recent != nil || recentErr != nil {
+		return recent, recentErr
+	}
+
+	loaded := &recentAttributes{attributes: map[string]map[string]bool{}}
+	if contextsFile != "" {
+		contexts, err := readContextsFile(contextsFile)
+		if err != nil {
+			recentErr = err
+			return nil, recentErr
+		}
+		observed := observeContexts(contexts)
+		for kind, attributes := range observed.attributes {
+			loaded.attributes[kind] = map[string]bool{}
+			for attribute := range attributes {
+				loaded.attributes[kind][attribute] = true
+			}
+		}
+		loaded.nested = true
+	} else {
+		names, r, err := client.ContextsBetaApi.GetContextAttributeNames(ctx, projectKey, envKey).Execute()
+		if err != nil {
+			recentErr = fmt.Errorf("error when calling `ContextsBetaApi.GetContextAttributeNames`: %v (full HTTP response: %v)", err, r)
+			return nil, recentErr
+		}
+		for _, kind := range names.Items {
+			loaded.attributes[kind.Kind] = map[string]bool{}
+			for _, name := range kind.Names {
+				loaded.attributes[kind.Kind][escapeAttributeRef([]string{name.Name})] = true
+			}
+		}
+	}
+
+	recent = loaded
+	return recent, nil
+}
+
+// Returns true if contexts of the kind have been seen recently
+func (r recentAttributes) hasKind(kind string) bool {
+	_, ok := r.attributes[kind]
+	return ok
+}
+
+// Returns true if contexts of the kind have recently been seen with the attribute. Every context has a key.
+func (r recentAttributes) has(kind string, attribute string) bool {
+	if !r.hasKind(kind) {
+		return false
+	}
+	if sameAttribute(attribute, keyAttribute) || r.attributes[kind][canonicalAttribute(attribute)] {
+		return true
+	}
+
+	components, err := parseAttributeRef(attribute)
+	return err == nil && !r.nested && r.attributes[kind][escapeAttributeRef(components[:1])]
+}
+
+// Load sample contexts from CONTEXTS_FILE, or from the contexts that LaunchDarkly has recently seen in the
+// environment when no file is provided
+func loadSampleContexts() ([]map[string]interface{}, error) {
+	if contextsFile != "" {
+		return readContextsFile(contextsFile)
+	}
+
+	requireAPIKey()
+	contexts := []map[string]interface{}{}
+	var continuationToken *string
+	for len(contexts) < contextSampleSize {
+		req := client.ContextsBetaApi.SearchContexts(ctx, projectKey, envKey).Limit(contextPageSize)
+		if continuationToken != nil {
+			req = req.ContinuationToken(*continuationToken)
+		}
+		page, r, err := req.Execute()
+		if err != nil {
+			return nil, fmt.Errorf("error when calling `ContextsBetaApi.SearchContexts`: %v (full HTTP response: %v)", err, r)
+		}
+
+		for _, record := range page.Items {
+			if context, ok := record.Context.(map[string]interface{}); ok {
+				contexts = append(contexts, context)
+			}
+		}
+		if page.ContinuationToken == nil || len(page.Items) == 0 {
+			break
+		}
+		continuationToken = page.ContinuationToken
+	}
+
+	return contexts, nil
+}
+
+// Read a JSONL export with one context or legacy user per line. Blank lines are skipped.
+func readContextsFile(file string) ([]map[string]interface{}, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	contexts := []map[string]interface{}{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var context map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &context); err != nil {
+			return nil, fmt.Errorf("line %v of '%v' isn't a JSON object: %v", line, file, err)
+		}
+		contexts = append(contexts, context)
+	}
+
+	return contexts, scanner.Err()
+}
+
+// Record the kinds and attributes of each sample context. A multi-context is split into its individual contexts,
+// and an object without a kind is treated as a legacy user whose `custom` attributes are top-level attributes.
+func observeContexts(contexts []map[string]interface{}) observedContexts {
+	observed := observedContexts{
+		kinds:      map[string]int{},
+		attributes: map[string]map[string]*observedAttribute{},
+	}
+
+	for _, context := range contexts {
+		observed.total++
+
+		kind, _ := context["kind"].(string)
+		switch kind {
+		case "multi":
+			for kind, value := range context {
+				if individual, ok := value.(map[string]interface{}); ok && kind != "kind" {
+					observed.observe(kind, individual)
+				}
+			}
+		case "":
+			user := map[string]interface{}{}
+			for name, value := range context {
+				user[name] = value
+			}
+			delete(user, "privateAttributeNames")
+			if custom, ok := context["custom"].(map[string]interface{}); ok {
+				delete(user, "custom")
+				for name, value := range custom {
+					user[name] = value
+				}
+			}
+			observed.observe(userKind, user)
+		default:
+			observed.observe(kind, context)
+		}
+	}
+
+	return observed
+}
+
+// Record the attributes of an individual context, including the attributes nested within its JSON objects
+func (o *observedContexts) observe(kind string, context map[string]interface{}) {
+	o.kinds[kind]++
+	if o.attributes[kind] == nil {
+		o.attributes[kind] = map[string]*observedAttribute{}
+	}
+
+	var walk func(components []string, value interface{})
+	walk = func(components []string, value interface{}) {
+		ref := escapeAttributeRef(components)
+		attribute := o.attributes[kind][ref]
+		if attribute == nil {
+			attribute = &observedAttribute{values: map[string]bool{}}
+			o.attributes[kind][ref] = attribute
+		}
+		attribute.count++
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for name, nested := range v {
+				walk(append(append([]string{}, components...), name), nested)
+			}
+		case []interface{}:
+			// Clauses match an array attribute when any of its elements match
+			for _, element := range v {
+				attribute.values[fmt.Sprint(element)] = true
+			}
+		default:
+			attribute.values[fmt.Sprint(v)] = true
+		}
+	}
+
+	for name, value := range context {
+		if name == "kind" || name == "_meta" {
+			continue
+		}
+		walk([]string{name}, value)
+	}
+}
+
+// Helper function to get the observed kinds in a stable order
+func (o observedContexts) sortedKinds() []string {
+	kinds := make([]string, 0, len(o.kinds))
+	for kind := range o.kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
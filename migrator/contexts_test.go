@@ -0,0 +1,38 @@
+package migrator
+
+import (
+	"testing"
+)
+
+func TestRecentAttributesHas(t *testing.T) {
+	attributes := map[string]map[string]bool{
+		"account": {"/name": true, "/address": true, "/a~1b": true},
+	}
+
+	tests := []struct {
+		name      string
+		nested    bool
+		kind      string
+		attribute string
+		want      bool
+	}{
+		{"unseen kind", false, "device", "name", false},
+		{"key of a seen kind", false, "account", keyAttribute, true},
+		{"literal name", false, "account", "name", true},
+		{"reference", false, "account", "/name", true},
+		{"escaped name", false, "account", "a/b", true},
+		{"unseen attribute", false, "account", "plan", false},
+		{"nested reference with top-level names", false, "account", "/address/zip", true},
+		{"nested reference with nested names", true, "account", "/address/zip", false},
+		{"unseen top-level name", false, "account", "/location/zip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recent := recentAttributes{attributes: attributes, nested: tt.nested}
+			if got := recent.has(tt.kind, tt.attribute); got != tt.want {
+				t.Errorf("has(%v, %v) = %v, want %v", tt.kind, tt.attribute, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package migrator
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+// Policy rules can hold a `when` expression over flag fields, such as
+//
+//	'billing' in tags or (not temporary and created < '2021-01-01')
+//
+// Expressions combine comparisons with `and`, `or`, `not` and parentheses. A comparison compares a flag field with a
+// quoted string, `true` or `false`, or a list of strings such as `['a', 'b']`. Expressions are parsed and type checked
+// when the policy file is loaded, so evaluating them can't fail.
+
+// The types of the values in an expression
+const (
+	exprString = "string"
+	exprBool   = "boolean"
+	exprTime   = "date"
+	exprList   = "list"
+)
+
+// The flag fields that expressions can refer to, with their types
+var policyFields = map[string]string{
+	"key":        exprString,
+	"name":       exprString,
+	"kind":       exprString,
+	"tags":       exprList,
+	"temporary":  exprBool,
+	"archived":   exprBool,
+	"created":    exprTime,
+	"maintainer": exprList,
+}
+
+// The comparison operators that each type of field supports. A list field matches when any of its values does.
+var policyOperators = map[string][]string{
+	exprString: {"==", "!=", "matches", "in"},
+	exprList:   {"==", "!=", "matches", "in"},
+	exprBool:   {"==", "!="},
+	exprTime:   {"==", "!=", "<", "<=", ">", ">="},
+}
+
+type policyExpr interface {
+	eval(flag ldapi.FeatureFlag) bool
+}
+
+type andExpr struct{ left, right policyExpr }
+type orExpr struct{ left, right policyExpr }
+type notExpr struct{ expr policyExpr }
+
+// A boolean field on its own, such as `temporary`
+type fieldExpr struct{ field string }
+
+// A comparison of a flag field with a literal value
+type comparisonExpr struct {
+	field string
+	op    string
+	value interface{} // A string, bool, time.Time or []string, depending on the field and operator
+}
+
+func (e andExpr) eval(flag ldapi.FeatureFlag) bool { return e.left.eval(flag) && e.right.eval(flag) }
+func (e orExpr) eval(flag ldapi.FeatureFlag) bool  { return e.left.eval(flag) || e.right.eval(flag) }
+func (e notExpr) eval(flag ldapi.FeatureFlag) bool { return !e.expr.eval(flag) }
+
+func (e fieldExpr) eval(flag ldapi.FeatureFlag) bool {
+	return policyFieldValue(flag, e.field).(bool)
+}
+
+func (e comparisonExpr) eval(flag ldapi.FeatureFlag) bool {
+	switch fieldValue := policyFieldValue(flag, e.field).(type) {
+	case bool:
+		return (fieldValue == e.value.(bool)) == (e.op == "==")
+	case time.Time:
+		other := e.value.(time.Time)
+		switch e.op {
+		case "==":
+			return fieldValue.Equal(other)
+		case "!=":
+			return !fieldValue.Equal(other)
+		case "<":
+			return fieldValue.Before(other)
+		case "<=":
+			return !fieldValue.After(other)
+		case ">":
+			return fieldValue.After(other)
+		case ">=":
+			return !fieldValue.Before(other)
+		}
+	case string:
+		return compareString(fieldValue, e.op, e.value)
+	case []string:
+		// A list field doesn't equal a value when none of its values do
+		if e.op == "!=" {
+			for _, item := range fieldValue {
+				if compareString(item, "==", e.value) {
+					return false
+				}
+			}
+			return true
+		}
+		for _, item := range fieldValue {
+			if compareString(item, e.op, e.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func compareString(fieldValue string, op string, value interface{}) bool {
+	switch op {
+	case "==":
+		return fieldValue == value.(string)
+	case "!=":
+		return fieldValue != value.(string)
+	case "matches":
+		isMatch, _ := path.Match(value.(string), fieldValue)
+		return isMatch
+	case "in":
+		return contains(value.([]string), fieldValue)
+	}
+	return false
+}
+
+// Helper function to get the value of a flag field that expressions can refer to
+func policyFieldValue(flag ldapi.FeatureFlag, field string) interface{} {
+	switch field {
+	case "key":
+		return flag.Key
+	case "name":
+		return flag.Name
+	case "kind":
+		return flag.Kind
+	case "tags":
+		return flag.Tags
+	case "temporary":
+		return flag.Temporary
+	case "archived":
+		return flag.Archived
+	case "created":
+		return time.UnixMilli(flag.CreationDate)
+	case "maintainer":
+		maintainers := []string{}
+		teamKey, memberId, memberEmail := getMaintainer(flag)
+		for _, maintainer := range []string{teamKey, memberId, memberEmail} {
+			if maintainer != "" {
+				maintainers = append(maintainers, maintainer)
+			}
+		}
+		return maintainers
+	}
+	return nil
+}
+
+// A token of an expression: a word, a quoted string, or punctuation
+type exprToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeExpr(expression string) ([]exprToken, error) {
+	tokens := []exprToken{}
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '\'' || c == '"':
+			var text strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != c; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				text.WriteRune(runes[j])
+			}
+			if j == len(runes) {
+				return nil, fmt.Errorf("a string isn't closed")
+			}
+			tokens = append(tokens, exprToken{text.String(), true})
+			i = j + 1
+		case strings.ContainsRune("()[],", c):
+			tokens = append(tokens, exprToken{string(c), false})
+			i++
+		case strings.ContainsRune("=!<>", c):
+			op := string(c)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			if op == "=" || op == "!" {
+				return nil, fmt.Errorf("unknown operator '%v'", op)
+			}
+			tokens = append(tokens, exprToken{op, false})
+			i += len(op)
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, exprToken{string(runes[i:j]), false})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character '%c'", c)
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+// Parse and type check a policy expression
+func parsePolicyExpr(expression string) (policyExpr, error) {
+	tokens, err := tokenizeExpr(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%v'", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+// Helper function to check whether the next token is an unquoted word or punctuation, and consume it if it is
+func (p *exprParser) accept(text string) bool {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && p.tokens[p.pos].text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) next() (exprToken, error) {
+	if p.pos == len(p.tokens) {
+		return exprToken{}, fmt.Errorf("the expression ends too early")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *exprParser) parseOr() (policyExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("or") {
+		var right policyExpr
+		if right, err = p.parseAnd(); err == nil {
+			left = orExpr{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (policyExpr, error) {
+	left, err := p.parseNot()
+	for err == nil && p.accept("and") {
+		var right policyExpr
+		if right, err = p.parseNot(); err == nil {
+			left = andExpr{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseNot() (policyExpr, error) {
+	if p.accept("not") {
+		expr, err := p.parseNot()
+		return notExpr{expr}, err
+	}
+	if p.accept("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("a '(' isn't closed")
+		}
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+// Parse a boolean field on its own, a comparison of a field with a value, or a value `in` a list field
+func (p *exprParser) parseComparison() (policyExpr, error) {
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	// A quoted string can only be tested for membership in a list field, as in `'billing' in tags`
+	if token.quoted {
+		if !p.accept("in") {
+			return nil, fmt.Errorf("expected 'in' after '%v'", token.text)
+		}
+		field, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if policyFields[field.text] != exprList || field.quoted {
+			return nil, fmt.Errorf("'%v' in ... needs a list field, such as tags or maintainer", token.text)
+		}
+		return comparisonExpr{field.text, "==", token.text}, nil
+	}
+
+	fieldType, isField := policyFields[token.text]
+	if !isField {
+		return nil, fmt.Errorf("unknown field '%v'", token.text)
+	}
+
+	op := ""
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted {
+		if candidate := p.tokens[p.pos].text; contains(policyOperators[exprString], candidate) || contains(policyOperators[exprTime], candidate) {
+			op = candidate
+			p.pos++
+		}
+	}
+	if op == "" {
+		if fieldType != exprBool {
+			return nil, fmt.Errorf("field '%v' needs a comparison", token.text)
+		}
+		return fieldExpr{token.text}, nil
+	}
+	if !contains(policyOperators[fieldType], op) {
+		return nil, fmt.Errorf("operator '%v' can't compare field '%v' of type %v", op, token.text, fieldType)
+	}
+
+	value, err := p.parseValue(token.text, fieldType, op)
+	if err != nil {
+		return nil, err
+	}
+	return comparisonExpr{token.text, op, value}, nil
+}
+
+// Parse the literal that a field is compared with, checking that it suits the field's type
+func (p *exprParser) parseValue(field string, fieldType string, op string) (interface{}, error) {
+	if op == "in" {
+		if !p.accept("[") {
+			return nil, fmt.Errorf("'%v in' needs a list of strings, such as ['a', 'b']", field)
+		}
+		values := []string{}
+		for !p.accept("]") {
+			if len(values) > 0 && !p.accept(",") {
+				return nil, fmt.Errorf("expected ',' or ']' in a list")
+			}
+			token, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			if !token.quoted {
+				return nil, fmt.Errorf("lists can only hold quoted strings, but found '%v'", token.text)
+			}
+			values = append(values, token.text)
+		}
+		return values, nil
+	}
+
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch fieldType {
+	case exprBool:
+		if token.quoted || (token.text != "true" && token.text != "false") {
+			return nil, fmt.Errorf("field '%v' can only be compared with true or false", field)
+		}
+		return token.text == "true", nil
+	case exprTime:
+		date, err := parsePolicyDate(token.text)
+		if !token.quoted || err != nil {
+			return nil, fmt.Errorf("field '%v' can only be compared with a quoted date, such as '2021-01-01'", field)
+		}
+		return date, nil
+	}
+	if !token.quoted {
+		return nil, fmt.Errorf("field '%v' can only be compared with a quoted string, but found '%v'", field, token.text)
+	}
+	if op == "matches" {
+		if _, err := path.Match(token.text, ""); err != nil {
+			return nil, fmt.Errorf("'%v' is an invalid pattern", token.text)
+		}
+	}
+	return token.text, nil
+}
@@ -0,0 +1,122 @@
+package migrator
+
+import (
+	"testing"
+	"time"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+func TestPolicyExpressions(t *testing.T) {
+	teamKey := "payments-team"
+	flag := ldapi.FeatureFlag{
+		Key:               "billing-checkout",
+		Name:              "Billing checkout",
+		Kind:              "boolean",
+		Tags:              []string{"billing", "web"},
+		Temporary:         false,
+		CreationDate:      time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC).UnixMilli(),
+		MaintainerTeamKey: &teamKey,
+	}
+
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		{"'billing' in tags", true},
+		{"'mobile' in tags", false},
+		{"tags == 'web'", true},
+		{"tags != 'web'", false},
+		{"tags != 'mobile'", true},
+		{"temporary", false},
+		{"not temporary", true},
+		{"temporary == false", true},
+		{"archived != true", true},
+		{"key == 'billing-checkout'", true},
+		{"key != 'billing-checkout'", false},
+		{"key matches 'billing-*'", true},
+		{"key matches 'pay-*'", false},
+		{"key in ['a', 'billing-checkout']", true},
+		{"kind in ['multivariate']", false},
+		{"name == \"Billing checkout\"", true},
+		{"created < '2021-01-01'", true},
+		{"created >= '2021-01-01'", false},
+		{"created > '2020-01-01T00:00:00Z'", true},
+		{"maintainer == 'payments-team'", true},
+		{"'payments-team' in maintainer", true},
+		{"'billing' in tags and temporary", false},
+		{"'billing' in tags or temporary", true},
+		{"'mobile' in tags or (not temporary and created < '2021-01-01')", true},
+		{"not ('billing' in tags and key matches 'billing-*')", false},
+		{"temporary or 'web' in tags and 'mobile' in tags", false},
+		{"not temporary and not archived", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			expr, err := parsePolicyExpr(tt.expression)
+			if err != nil {
+				t.Fatalf("parsePolicyExpr() error = %v", err)
+			}
+			if got := expr.eval(flag); got != tt.want {
+				t.Errorf("eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyExpressionErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"owner == 'a'",
+		"tags",
+		"key",
+		"temporary == 'yes'",
+		"created < 'last year'",
+		"created matches '2021-*'",
+		"temporary < true",
+		"key == billing",
+		"key in 'a'",
+		"key in ['a' 'b']",
+		"'billing' in key",
+		"'billing' tags",
+		"(temporary",
+		"temporary)",
+		"key = 'a'",
+		"key == 'a",
+		"temporary and",
+		"key matches '['",
+		"key == 'a' # comment",
+	}
+
+	for _, expression := range tests {
+		t.Run(expression, func(t *testing.T) {
+			if _, err := parsePolicyExpr(expression); err == nil {
+				t.Errorf("parsePolicyExpr(%q) succeeded, want an error", expression)
+			}
+		})
+	}
+}
+
+func TestPolicyRuleWithExpression(t *testing.T) {
+	rule := policyRule{When: "'billing' in tags or temporary", FlagKeys: []string{"checkout-*"}}
+	if err := validatePolicyRule(&rule); err != nil {
+		t.Fatalf("validatePolicyRule() error = %v", err)
+	}
+
+	tests := []struct {
+		flag ldapi.FeatureFlag
+		want bool
+	}{
+		{ldapi.FeatureFlag{Key: "checkout-new", Tags: []string{"billing"}}, true},
+		{ldapi.FeatureFlag{Key: "checkout-new", Temporary: true}, true},
+		{ldapi.FeatureFlag{Key: "checkout-new"}, false},
+		{ldapi.FeatureFlag{Key: "search", Tags: []string{"billing"}}, false},
+	}
+
+	for _, tt := range tests {
+		if got := rule.matchesFlag(tt.flag); got != tt.want {
+			t.Errorf("matchesFlag(%v) = %v, want %v", tt.flag.Key, got, tt.want)
+		}
+	}
+}
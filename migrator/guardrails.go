@@ -0,0 +1,343 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+// A Guardrail decides whether a flag is safe to migrate. Check returns a message for each reason the flag isn't safe
+// to migrate, or an error when the guardrail can't tell. A flag is only migrated when every enabled guardrail returns
+// no violations. An error is reported, and only blocks the flag when the guardrail fails closed or GUARDRAIL_ERRORS
+// is block.
+type Guardrail interface {
+	Name() string
+	Check(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error)
+}
+
+// A guardrail that also implements FailClosed, and returns true, blocks every flag it can't check
+type FailClosed interface {
+	FailClosed() bool
+}
+
+type guardrailFunc struct {
+	name       string
+	check      func(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error)
+	failClosed bool
+}
+
+func (g guardrailFunc) Name() string {
+	return g.name
+}
+
+func (g guardrailFunc) Check(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error) {
+	return g.check(ctx, flag)
+}
+
+func (g guardrailFunc) FailClosed() bool {
+	return g.failClosed
+}
+
+// NewGuardrail creates a Guardrail from a name and a check function. Errors from the check are reported without
+// blocking the flag.
+func NewGuardrail(name string, check func(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error)) Guardrail {
+	return guardrailFunc{name, check, false}
+}
+
+// NewFailClosedGuardrail creates a Guardrail from a name and a check function. Errors from the check block the flag.
+func NewFailClosedGuardrail(name string, check func(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error)) Guardrail {
+	return guardrailFunc{name, check, true}
+}
+
+const (
+	experimentPageSize = 50
+	iterationStopped   = "stopped"
+)
+
+// The outcome of running one guardrail against a flag
+type guardrailResult struct {
+	name       string
+	violations []string
+	err        error
+	failClosed bool
+}
+
+// The registered guardrails, in the order they run. The built-in guardrails are the default set. The guardrails that
+// protect running code fail closed, since a flag they can't check could break it. The active-measurements guardrail
+// can't tell for every flag with a percentage rollout, so it only blocks those flags under GUARDRAIL_ERRORS=block.
+var guardrails = []Guardrail{
+	NewFailClosedGuardrail("unsafe-repositories", checkUnsafeRepos),
+	NewGuardrail("active-measurements", checkActiveMeasurements),
+	NewGuardrail("partial-rules", checkPartialRules),
+	NewGuardrail("context-attributes", checkContextAttributes),
+	NewGuardrail("attribute-types", checkAttributeTypes),
+	NewGuardrail("pending-changes", checkPendingChanges),
+	NewGuardrail("policy", checkPolicy),
+	NewFailClosedGuardrail("external-command", checkExternalCommand),
+	NewGuardrail(clusterGuardrailName, checkPrerequisiteCluster),
+}
+
+// Earlier names of guardrails, which GUARDRAILS still accepts
+var guardrailAliases = map[string]string{
+	"dependent-flags":     clusterGuardrailName,
+	"running-experiments": "active-measurements",
+}
+
+// The guardrails that check a flag against the results of the other guardrails for every selected flag, so they only
+// run once the other guardrails have checked them all
+var deferredGuardrails = []string{clusterGuardrailName}
+
+// RegisterGuardrail adds a guardrail that runs for every flag that targets users. A guardrail with the same name as a
+// registered one replaces it.
+func RegisterGuardrail(guardrail Guardrail) {
+	for i, registered := range guardrails {
+		if registered.Name() == guardrail.Name() {
+			guardrails[i] = guardrail
+			return
+		}
+	}
+	guardrails = append(guardrails, guardrail)
+}
+
+// Helper function to get the guardrails that GUARDRAILS enables. Names prefixed with '-' are disabled. When any name
+// isn't prefixed, only the named guardrails are enabled; otherwise every other registered guardrail is.
+func enabledGuardrails() []Guardrail {
+	enabled := []string{}
+	disabled := []string{}
+	for _, name := range guardrailNames {
+		isDisabled := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		if renamed, isAlias := guardrailAliases[name]; isAlias {
+			name = renamed
+		}
+		if isDisabled {
+			disabled = append(disabled, name)
+		} else {
+			enabled = append(enabled, name)
+		}
+	}
+
+	registered := []string{}
+	for _, guardrail := range guardrails {
+		registered = append(registered, guardrail.Name())
+	}
+	for _, name := range append(append([]string{}, enabled...), disabled...) {
+		if !contains(registered, name) {
+			log.Fatalf("GUARDRAILS names an unknown guardrail '%v'. The registered guardrails are: %v.", name, strings.Join(registered, ", "))
+			os.Exit(11)
+		}
+	}
+
+	result := []Guardrail{}
+	for _, guardrail := range guardrails {
+		if (len(enabled) == 0 || contains(enabled, guardrail.Name())) && !contains(disabled, guardrail.Name()) {
+			result = append(result, guardrail)
+		}
+	}
+	return result
+}
+
+// Run the enabled guardrails against a flag, either the deferred guardrails or all of the others
+func runGuardrails(flag ldapi.FeatureFlag, deferred bool) []guardrailResult {
+	results := []guardrailResult{}
+	for _, guardrail := range enabledGuardrails() {
+		if contains(deferredGuardrails, guardrail.Name()) != deferred {
+			continue
+		}
+		violations, err := guardrail.Check(ctx, flag)
+		failClosed := blockGuardrailErrors
+		if f, isFailClosed := guardrail.(FailClosed); isFailClosed && f.FailClosed() {
+			failClosed = true
+		}
+		results = append(results, guardrailResult{guardrail.Name(), violations, err, failClosed})
+	}
+	return results
+}
+
+// Helper function to get the outcome of a guardrail as a single word
+func (r guardrailResult) status() string {
+	if r.err != nil {
+		return "unknown"
+	} else if len(r.violations) > 0 {
+		return "failed"
+	}
+	return "passed"
+}
+
+// Returns true if the outcome of the guardrail keeps the flag from being migrated
+func (r guardrailResult) blocks() bool {
+	return len(r.violations) > 0 || (r.err != nil && r.failClosed)
+}
+
+// Print the outcome of each guardrail that ran against a flag
+func printGuardrailResults(results []guardrailResult) {
+	statuses := []string{}
+	for _, result := range results {
+		statuses = append(statuses, result.name+" "+result.status())
+	}
+	if len(statuses) > 0 {
+		fmt.Printf("  Guardrails: %v\n", strings.Join(statuses, ", "))
+	}
+}
+
+func checkUnsafeRepos(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error) {
+	if len(repos) == 0 && !autoRepos {
+		// skip the guardrail check because all repos are "ready"
+		return nil, nil
+	}
+
+	referencingRepos, err := reposReferencing(ctx, flag.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, repo := range referencingRepos {
+		if !contains(repos, repo) {
+			return []string{"The flag is referenced in one or more unsafe repositories."}, nil
+		}
+	}
+
+	// If we've reached this point, the script argument denotes that at least one repository is "safe".
+	// Let's mark repositories with no code references as "unsafe" because we don't know whether or not they're safe.
+	if len(referencingRepos) == 0 {
+		return []string{"The flag is referenced in one or more unsafe repositories."}, nil
+	}
+	return nil, nil
+}
+
+// Helper function to get the names of the repositories that refer to a flag, from the local repositories when
+// LOCAL_REPOSITORIES is provided and from code references otherwise
+func reposReferencing(ctx context.Context, flagKey string) ([]string, error) {
+	if len(localRepos) > 0 {
+		return localReposReferencing(flagKey)
+	}
+
+	stats, r, err := client.CodeReferencesApi.GetStatistics(ctx, projectKey).FlagKey(flagKey).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("code references is an Enterprise feature, so your LaunchDarkly account must be on an Enterprise plan to use this guardrail. To scan repositories on disk instead, provide LOCAL_REPOSITORIES. Error when calling `CodeReferencesApi.GetStatistics`: %v (full HTTP response: %v)", err, r)
+	}
+
+	names := []string{}
+	for _, stat := range stats.Flags[flagKey] {
+		names = append(names, stat.Name)
+	}
+	return names, nil
+}
+
+// Fail if the flag is part of a measurement that migrating it would disturb. That's an experiment with an iteration
+// that's running, or that hasn't started or ended yet, or a rollout that's allocated to an experiment. Iterations that
+// have stopped don't count. Statuses that this client doesn't know yet, such as paused or scheduled iterations, count
+// as live. This client can't read holdouts or guarded rollouts, so they aren't detected. The result is unknown for a
+// flag with any other percentage rollout, which either could be measuring.
+func checkActiveMeasurements(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error) {
+	violations := []string{}
+
+	flagConfig := flag.Environments[envKey]
+	rollouts := []*ldapi.Rollout{}
+	for _, rule := range flagConfig.Rules {
+		rollouts = append(rollouts, rule.Rollout)
+	}
+	if flagConfig.Fallthrough != nil {
+		rollouts = append(rollouts, flagConfig.Fallthrough.Rollout)
+	}
+
+	unallocated := 0
+	for i, rollout := range rollouts {
+		if rollout == nil {
+			continue
+		} else if rollout.ExperimentAllocation == nil {
+			unallocated++
+		} else if i < len(flagConfig.Rules) {
+			violations = append(violations, fmt.Sprintf("The percentage rollout of rule %v is allocated to an experiment.", i+1))
+		} else {
+			violations = append(violations, "The fallthrough percentage rollout is allocated to an experiment.")
+		}
+	}
+
+	var offset int64
+	for {
+		exps, r, err := client.ExperimentsBetaApi.GetExperiments(ctx, projectKey, envKey).Filter("flagKey:" + flag.Key).Expand("draftIteration").Limit(experimentPageSize).Offset(offset).Execute()
+		if r != nil && r.StatusCode == 403 {
+			// Without Experimentation, or without access to it, the script can't tell whether the flag is measured
+			return nil, fmt.Errorf("the API key can't read experiments, so experiments that use the flag can't be found. If your account doesn't have Experimentation, disable this guardrail with GUARDRAILS=-active-measurements")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error when calling `ExperimentsBetaApi.GetExperiments`: %v (full HTTP response: %v)", err, r)
+		}
+
+		for _, exp := range exps.Items {
+			if exp.CurrentIteration != nil && exp.CurrentIteration.Status != iterationStopped {
+				violations = append(violations, fmt.Sprintf("The flag is used in experiment '%v', which is %v.", exp.Key, describeIterationStatus(exp.CurrentIteration.Status)))
+			} else if exp.DraftIteration != nil && exp.DraftIteration.Status != iterationStopped {
+				violations = append(violations, fmt.Sprintf("The flag is used in experiment '%v', which has a draft iteration that hasn't started yet.", exp.Key))
+			}
+		}
+
+		offset += int64(len(exps.Items))
+		if len(exps.Items) == 0 || exps.TotalCount == nil || offset >= int64(*exps.TotalCount) {
+			break
+		}
+	}
+
+	if len(violations) == 0 && unallocated > 0 {
+		return nil, fmt.Errorf("the flag has %v percentage rollout(s) that aren't allocated to an experiment, and this version of the LaunchDarkly API client can't read holdouts or guarded rollouts, so the script can't tell whether they measure the flag. Check the flag in LaunchDarkly before applying its migration, and to block flags like this one, provide GUARDRAIL_ERRORS=block", unallocated)
+	}
+	return violations, nil
+}
+
+// Helper function to describe the status of an experiment iteration
+func describeIterationStatus(status string) string {
+	switch status {
+	case "running":
+		return "running"
+	case "not_started":
+		return "waiting to start"
+	}
+	return fmt.Sprintf("in state '%v'", status)
+}
+
+func checkPartialRules(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error) {
+	if partialRulePolicy == partialRuleSkipFlag && hasPartiallyMappedRules(flag, userReferences(flag)) {
+		return []string{"The flag has targeting rules where only some user attributes are mapped."}, nil
+	}
+	return nil, nil
+}
+
+// Fail for the context kinds and attributes that the flag's migration maps to, but that haven't been seen in recent
+// contexts. A flag migrated to those would stop matching until the SDKs send them.
+func checkContextAttributes(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error) {
+	if len(schema) == 0 {
+		return nil, nil
+	}
+
+	recent, err := getRecentAttributes()
+	if err != nil {
+		return nil, err
+	}
+
+	violations := []string{}
+	unseenKinds := []string{}
+	for _, destination := range flagDestinations(flag, userReferences(flag)) {
+		if !recent.hasKind(destination.Kind) {
+			if contains(unseenKinds, destination.Kind) {
+				continue
+			}
+			unseenKinds = append(unseenKinds, destination.Kind)
+			violations = append(violations, fmt.Sprintf("The schema maps to context kind '%v', which hasn't been seen in recent contexts.", destination.Kind))
+		} else if !recent.has(destination.Kind, destination.Attribute) {
+			violations = append(violations, fmt.Sprintf("The schema maps to '%v' attribute '%v', which hasn't been seen in recent contexts.", destination.Kind, destination.Attribute))
+		}
+	}
+	return violations, nil
+}
+
+func checkAttributeTypes(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error) {
+	if !blockTypeMismatches {
+		return nil, nil
+	}
+	return typeMismatches(flag, userReferences(flag)), nil
+}
@@ -0,0 +1,140 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+func TestGuardrailResultBlocks(t *testing.T) {
+	tests := []struct {
+		name       string
+		result     guardrailResult
+		wantStatus string
+		wantBlocks bool
+	}{
+		{"passed", guardrailResult{name: "g"}, "passed", false},
+		{"failed", guardrailResult{name: "g", violations: []string{"no"}}, "failed", true},
+		{"unknown", guardrailResult{name: "g", err: errors.New("down")}, "unknown", false},
+		{"unknown and fail closed", guardrailResult{name: "g", err: errors.New("down"), failClosed: true}, "unknown", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.result.status(); got != tt.wantStatus {
+				t.Errorf("status() = %v, want %v", got, tt.wantStatus)
+			}
+			if got := tt.result.blocks(); got != tt.wantBlocks {
+				t.Errorf("blocks() = %v, want %v", got, tt.wantBlocks)
+			}
+		})
+	}
+}
+
+func TestRunGuardrailsFailClosed(t *testing.T) {
+	savedGuardrails, savedNames, savedBlock := guardrails, guardrailNames, blockGuardrailErrors
+	t.Cleanup(func() { guardrails, guardrailNames, blockGuardrailErrors = savedGuardrails, savedNames, savedBlock })
+
+	failing := func(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error) {
+		return nil, errors.New("unavailable")
+	}
+	guardrails = []Guardrail{NewGuardrail("open", failing), NewFailClosedGuardrail("closed", failing)}
+	guardrailNames = nil
+
+	for _, block := range []bool{false, true} {
+		blockGuardrailErrors = block
+		results := runGuardrails(ldapi.FeatureFlag{Key: "flag"}, false)
+		if len(results) != 2 {
+			t.Fatalf("runGuardrails() returned %v results, want 2", len(results))
+		}
+		if results[0].blocks() != block {
+			t.Errorf("with GUARDRAIL_ERRORS block %v, the fail-open guardrail blocks = %v", block, results[0].blocks())
+		}
+		if !results[1].blocks() {
+			t.Errorf("with GUARDRAIL_ERRORS block %v, the fail-closed guardrail doesn't block", block)
+		}
+	}
+}
+
+func TestEnabledGuardrailsAliases(t *testing.T) {
+	savedNames := guardrailNames
+	t.Cleanup(func() { guardrailNames = savedNames })
+
+	guardrailNames = []string{"-dependent-flags"}
+	for _, guardrail := range enabledGuardrails() {
+		if guardrail.Name() == clusterGuardrailName {
+			t.Errorf("GUARDRAILS=-dependent-flags didn't disable the %v guardrail", clusterGuardrailName)
+		}
+	}
+
+	guardrailNames = []string{"dependent-flags", "running-experiments"}
+	enabled := enabledGuardrails()
+	if len(enabled) != 2 || enabled[0].Name() != "active-measurements" || enabled[1].Name() != clusterGuardrailName {
+		t.Errorf("GUARDRAILS=dependent-flags,running-experiments enabled %v, want active-measurements and %v", enabled, clusterGuardrailName)
+	}
+}
+
+// Helper function to send the API calls of the rest of a test to a handler
+func useAPI(t *testing.T, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	savedClient := client
+	t.Cleanup(func() {
+		client = savedClient
+		server.Close()
+	})
+
+	config := ldapi.NewConfiguration()
+	config.Servers = ldapi.ServerConfigurations{{URL: server.URL}}
+	client = ldapi.NewAPIClient(config)
+}
+
+func TestCheckActiveMeasurementsRollouts(t *testing.T) {
+	useAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"items": [], "totalCount": 0}`))
+	})
+	savedNames, savedBlock := guardrailNames, blockGuardrailErrors
+	t.Cleanup(func() { guardrailNames, blockGuardrailErrors = savedNames, savedBlock })
+	guardrailNames, blockGuardrailErrors = []string{"active-measurements"}, false
+
+	experimentRollout := &ldapi.Rollout{ExperimentAllocation: &ldapi.ExperimentAllocationRep{}}
+	tests := []struct {
+		name        string
+		config      ldapi.FeatureFlagConfig
+		violations  int
+		wantUnknown bool
+		wantBlocks  bool
+	}{
+		{"no rollouts", ldapi.FeatureFlagConfig{}, 0, false, false},
+		{"rollout", ldapi.FeatureFlagConfig{Fallthrough: &ldapi.VariationOrRolloutRep{Rollout: &ldapi.Rollout{}}}, 0, true, false},
+		{"experiment rollout", ldapi.FeatureFlagConfig{Rules: []ldapi.Rule{{Rollout: experimentRollout}}}, 1, false, true},
+		{
+			"experiment and other rollouts",
+			ldapi.FeatureFlagConfig{Rules: []ldapi.Rule{{Rollout: &ldapi.Rollout{}}}, Fallthrough: &ldapi.VariationOrRolloutRep{Rollout: experimentRollout}},
+			1,
+			false,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flag := ldapi.FeatureFlag{Key: "flag", Environments: map[string]ldapi.FeatureFlagConfig{envKey: tt.config}}
+			results := runGuardrails(flag, false)
+			if len(results) != 1 {
+				t.Fatalf("runGuardrails() returned %v results, want 1", len(results))
+			}
+			result := results[0]
+			if len(result.violations) != tt.violations || (result.err != nil) != tt.wantUnknown {
+				t.Errorf("checkActiveMeasurements() = %v, %v, want %v violation(s) and unknown %v", result.violations, result.err, tt.violations, tt.wantUnknown)
+			}
+			if result.blocks() != tt.wantBlocks {
+				t.Errorf("blocks() = %v, want %v", result.blocks(), tt.wantBlocks)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package migrator
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	constructionWindow = 10  // The lines from a construction site on that are searched for the attributes it sets
+	maxSnippetLength   = 120 // The longest line of code the legacy users report shows for a construction site
+	maxReportedSites   = 20  // The most construction sites the legacy users report lists for each repository
+)
+
+// Code that builds a legacy user in each SDK language
+var legacyUserPatterns = []*regexp.Regexp{
+	// Go
+	regexp.MustCompile(`\blduser\.New(User|UserBuilder|AnonymousUser)\(`),
+	regexp.MustCompile(`\bld\.NewUser(Builder)?\(`),
+	// Java, Android and Swift
+	regexp.MustCompile(`\bLDUser(\.Builder)?\(`),
+	// .NET
+	regexp.MustCompile(`\bUser\.(Builder|WithKey)\(`),
+}
+
+// JavaScript, Python and Ruby pass users as plain objects with a key but no kind. An object is taken to be a user when
+// it's passed to an SDK call, or assigned to a variable that's named like a user or passed to an SDK call in the same
+// file.
+var (
+	sdkUserCall      = `\b(identify|initialize|variation|variation_detail|variationDetail|all_flags_state|allFlagsState)\(`
+	sdkCallObject    = regexp.MustCompile(sdkUserCall + `([^(){}]*,\s*)?\{`)
+	sdkCallArguments = regexp.MustCompile(sdkUserCall + `([^(){}]*)\)`)
+	assignedObject   = regexp.MustCompile(`([A-Za-z_$][\w$]*)\s*(:\s*[\w.]+\s*)?=\s*\{`)
+	identifier       = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+	userName         = regexp.MustCompile(`(?i)user`)
+)
+
+// A key property of a plain object, as in `key: ...`, `'key': ...` or `:key => ...`
+var keyProperty = regexp.MustCompile(`(^|[,\s]):?['"]?key['"]?\s*(:|=>)`)
+
+// Code that builds a context, which names the context kinds it sends
+var contextPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\bldcontext\.New(Builder|MultiBuilder|WithKind)?\(`),
+	regexp.MustCompile(`\bLDContext\.(create|builder|createMulti|multiBuilder)\(`),
+	regexp.MustCompile(`\bContext\.(Builder|New|NewWithKind|MultiBuilder|builder|create)\(`),
+	regexp.MustCompile(`\bLDContext\(`),
+	kindProperty,
+}
+
+// A plain object that names a context kind, as in `{ kind: 'user', key: ... }`
+var kindProperty = regexp.MustCompile(`['"]?\bkind['"]?\s*(:|=>)\s*['"]`)
+
+// A line of code that builds a legacy user or a context
+type constructionSite struct {
+	file    string
+	line    int
+	snippet string
+	window  string
+}
+
+// The legacy user and context construction sites of a repository
+type constructionSites struct {
+	legacyUsers []constructionSite
+	contexts    []constructionSite
+}
+
+// Find the lines of a repository that build legacy users or contexts. Plain objects that name a kind are contexts, so
+// they aren't counted as legacy users.
+func findConstructionSites(repo localRepository) (constructionSites, error) {
+	sites := constructionSites{}
+	err := walkRepository(repo, func(relPath string, content []byte) error {
+		lines := []string{}
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		scanner.Buffer(nil, maxScannedFileSize)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if scanner.Err() != nil {
+			return nil // Minified or generated files can have lines that are too long to scan
+		}
+		userVariables := sdkCallIdentifiers(string(content))
+
+		for i, line := range lines {
+			end := i + constructionWindow
+			if end > len(lines) {
+				end = len(lines)
+			}
+			site := constructionSite{relPath, i + 1, snippet(line), strings.Join(lines[i:end], "\n")}
+
+			if matchesAny(contextPatterns, line) {
+				sites.contexts = append(sites.contexts, site)
+			} else if matchesAny(legacyUserPatterns, line) || startsUserObject(lines, i, userVariables) {
+				sites.legacyUsers = append(sites.legacyUsers, site)
+			}
+		}
+		return nil
+	})
+	return sites, err
+}
+
+// Helper function to get the identifiers that a file passes to SDK calls that take a user, as in
+// `variation('flag', currentUser, false)`
+func sdkCallIdentifiers(content string) map[string]bool {
+	names := map[string]bool{}
+	for _, match := range sdkCallArguments.FindAllStringSubmatch(content, -1) {
+		for _, argument := range strings.Split(match[2], ",") {
+			if name := strings.TrimSpace(argument); identifier.MatchString(name) {
+				names[name] = true
+			}
+		}
+	}
+	return names
+}
+
+// Returns true if a line starts a plain object with a key but no kind that's passed to an SDK call, or that's
+// assigned to a variable that's named like a user or passed to an SDK call
+func startsUserObject(lines []string, i int, userVariables map[string]bool) bool {
+	starts := []int{}
+	for _, match := range sdkCallObject.FindAllStringIndex(lines[i], -1) {
+		starts = append(starts, match[1]-1)
+	}
+	for _, match := range assignedObject.FindAllStringSubmatchIndex(lines[i], -1) {
+		if name := lines[i][match[2]:match[3]]; userName.MatchString(name) || userVariables[name] {
+			starts = append(starts, match[1]-1)
+		}
+	}
+
+	for _, start := range starts {
+		object := topLevelObject(lines, i, start)
+		if keyProperty.MatchString(object) && !kindProperty.MatchString(object) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to get the top level of the object that starts at a brace, which may continue on the lines that
+// follow. Nested objects, arrays and calls are left out, so only the object's own properties remain.
+func topLevelObject(lines []string, i int, start int) string {
+	var object strings.Builder
+	depth := 0
+	for j := i; j < len(lines) && j < i+constructionWindow; j++ {
+		line := lines[j]
+		if j == i {
+			line = line[start:]
+		}
+		for _, c := range line {
+			if strings.ContainsRune("{[(", c) {
+				depth++
+			} else if strings.ContainsRune("}])", c) {
+				depth--
+				if depth == 0 {
+					return object.String()
+				}
+			} else if depth == 1 {
+				object.WriteRune(c)
+			}
+		}
+		object.WriteRune('\n')
+	}
+	return object.String()
+}
+
+func matchesAny(patterns []*regexp.Regexp, line string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to shorten a line of code for the report
+func snippet(line string) string {
+	line = strings.TrimSpace(line)
+	if len(line) > maxSnippetLength {
+		return line[:maxSnippetLength] + "..."
+	}
+	return line
+}
+
+// Helper function to get a pattern that finds an attribute name in code. Only the top-level name of a nested
+// reference is searched for, and its first letter may be either case, so `firstName` also matches `.FirstName(`.
+func attributeNamePattern(attribute string) *regexp.Regexp {
+	name := attribute
+	if components, err := parseAttributeRef(attribute); err == nil {
+		name = components[0]
+	}
+	if len(name) == 0 {
+		// An empty name can't be found in code, so the pattern matches nothing
+		return regexp.MustCompile(`[^\x00-\x{10FFFF}]`)
+	}
+	first, size := utf8.DecodeRuneInString(name)
+	firstCases := "(?:" + regexp.QuoteMeta(string(unicode.ToLower(first))) + "|" + regexp.QuoteMeta(string(unicode.ToUpper(first))) + ")"
+	return regexp.MustCompile(`(^|[^A-Za-z0-9_])` + firstCases + regexp.QuoteMeta(name[size:]) + `($|[^A-Za-z0-9_])`)
+}
+
+// Returns true if any of the sites mentions the attribute name
+func sitesMention(sites []constructionSite, pattern *regexp.Regexp) bool {
+	for _, site := range sites {
+		if pattern.MatchString(site.window) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if any of the context construction sites names the context kind and the attribute
+func sendsInKind(sites []constructionSite, kind string, pattern *regexp.Regexp) bool {
+	kindPattern := regexp.MustCompile(`['"]` + regexp.QuoteMeta(kind) + `['"]`)
+	for _, site := range sites {
+		if kindPattern.MatchString(site.window) && pattern.MatchString(site.window) {
+			return true
+		}
+	}
+	return false
+}
+
+// LegacyUsersReport lists the places where each local repository still builds legacy users, and the schema's mapped
+// attributes that those users set but that the repository doesn't send in their new context kinds yet
+func LegacyUsersReport() {
+	Configure()
+	requireLocalRepositories()
+
+	mapped := []string{}
+	for userAttribute, mapping := range schema {
+		if mapping.Kind != userKind && !sameAttribute(userAttribute, keyAttribute) {
+			mapped = append(mapped, userAttribute)
+		}
+	}
+	sort.Strings(mapped)
+
+	fmt.Println("Legacy user construction in local repositories:")
+
+	withLegacyUsers := []string{}
+	for _, repo := range localRepos {
+		fmt.Println()
+		sites, err := findConstructionSites(repo)
+		if err != nil {
+			fmt.Printf("Repository '%v' couldn't be scanned: %v\n", repo.name, err)
+			continue
+		}
+		if len(sites.legacyUsers) == 0 {
+			fmt.Printf("Repository '%v' doesn't build legacy users (%v)\n", repo.name, repo.path)
+			continue
+		}
+
+		withLegacyUsers = append(withLegacyUsers, repo.name)
+		fmt.Printf("Repository '%v' builds legacy users in %v place(s) (%v)\n", repo.name, len(sites.legacyUsers), repo.path)
+		for i, site := range sites.legacyUsers {
+			if i == maxReportedSites {
+				fmt.Printf("  ... and %v more\n", len(sites.legacyUsers)-maxReportedSites)
+				break
+			}
+			fmt.Printf("  %v:%v: %v\n", site.file, site.line, site.snippet)
+		}
+
+		if schemaFile == "" {
+			continue
+		}
+		missing := []string{}
+		for _, userAttribute := range mapped {
+			mapping := schema[userAttribute]
+			pattern := attributeNamePattern(userAttribute)
+			if sitesMention(sites.legacyUsers, pattern) && !sendsInKind(sites.contexts, mapping.Kind, attributeNamePattern(mapping.Attribute)) {
+				missing = append(missing, fmt.Sprintf("'%v' (to '%v' attribute '%v')", userAttribute, mapping.Kind, mapping.Attribute))
+			}
+		}
+		if len(missing) > 0 {
+			fmt.Printf("  Mapped attributes set on legacy users but not sent in their new context kinds yet: %v\n", strings.Join(missing, ", "))
+		} else {
+			fmt.Println("  Every mapped attribute set on legacy users is also sent in its new context kind.")
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("%v of %v repository(ies) still build legacy users", len(withLegacyUsers), len(localRepos))
+	if len(withLegacyUsers) > 0 {
+		fmt.Printf(": %v", strings.Join(withLegacyUsers, ", "))
+	}
+	fmt.Println(".")
+	if schemaFile == "" {
+		fmt.Println("Provide SCHEMA_FILE to find the mapped attributes that each repository doesn't send in their new context kinds yet.")
+	}
+}
@@ -0,0 +1,66 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindConstructionSitesInPlainObjects(t *testing.T) {
+	sites, err := findConstructionSites(localRepository{"fixtures", "testdata/legacyusers"})
+	if err != nil {
+		t.Fatalf("findConstructionSites() error = %v", err)
+	}
+
+	got := map[string][]int{}
+	for _, site := range sites.legacyUsers {
+		got[site.file] = append(got[site.file], site.line)
+	}
+	want := map[string][]int{
+		"app.js": {6, 9, 17},
+		"app.py": {4, 7},
+		"app.rb": {4, 8},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findConstructionSites() found legacy users at %v, want %v", got, want)
+	}
+}
+
+func TestTopLevelObject(t *testing.T) {
+	lines := []string{
+		"const user = {",
+		"  custom: { key: 'nested' },",
+		"  groups: ['a', 'b'],",
+		"  key: id(),",
+		"};",
+	}
+	object := topLevelObject(lines, 0, len(lines[0])-1)
+	if want := "\n  custom: ,\n  groups: ,\n  key: id,\n"; object != want {
+		t.Errorf("topLevelObject() = %q, want %q", object, want)
+	}
+}
+
+func TestAttributeNamePattern(t *testing.T) {
+	tests := []struct {
+		attribute string
+		code      string
+		want      bool
+	}{
+		{"firstName", "user.FirstName(name)", true},
+		{"firstName", "firstName: name", true},
+		{"firstName", "myFirstName: name", false},
+		{"/address/zip", "address: { zip: zip }", true},
+		{"élan", "Élan: true", true},
+		{"élan", "elan: true", false},
+		{"-tier", "'-tier': plan", true},
+		{"", "anything", false},
+		{"/", "anything", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.attribute+" "+tt.code, func(t *testing.T) {
+			if got := attributeNamePattern(tt.attribute).MatchString(tt.code); got != tt.want {
+				t.Errorf("attributeNamePattern(%q) matches %q = %v, want %v", tt.attribute, tt.code, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package migrator
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+// A mapping that replaces an attribute's default mapping for some flags or some values. Flag criteria (tags and key
+// patterns) select the flags the mapping applies to, and a value pattern selects the clause values and target keys it
+// applies to. When several criteria are set, all of them must match.
+type conditionalMapping struct {
+	FlagTags     []string `yaml:"flagTags"`
+	FlagKeys     []string `yaml:"flagKeys"`
+	ValuePattern string   `yaml:"valuePattern"`
+	Kind         string
+	Attribute    string
+	Type         string
+	Transforms   []valueTransform
+	valueRegex   *regexp.Regexp
+}
+
+// The values of a user clause, or the keys of an individual targets list, that move to one mapped destination
+type clauseDestination struct {
+	mapping attributeSchema
+	values  []interface{}
+}
+
+func (c conditionalMapping) toSchema() attributeSchema {
+	return attributeSchema{Kind: c.Kind, Attribute: c.Attribute, Type: c.Type, Transforms: c.Transforms}
+}
+
+// Returns true if the condition's flag criteria match the flag. Conditions without flag criteria match every flag.
+func (c conditionalMapping) matchesFlag(flag ldapi.FeatureFlag) bool {
+	if len(c.FlagTags) > 0 {
+		tagged := false
+		for _, tag := range flag.Tags {
+			tagged = tagged || contains(c.FlagTags, tag)
+		}
+		if !tagged {
+			return false
+		}
+	}
+
+	if len(c.FlagKeys) > 0 {
+		matched := false
+		for _, pattern := range c.FlagKeys {
+			if isMatch, _ := path.Match(pattern, flag.Key); isMatch {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check a conditional mapping's criteria and destination. Value patterns are compiled here so that they're ready
+// when the mapping is applied.
+func validateCondition(condition *conditionalMapping) error {
+	if len(condition.FlagTags) == 0 && len(condition.FlagKeys) == 0 && condition.ValuePattern == "" {
+		return fmt.Errorf("needs at least one of flagTags, flagKeys or valuePattern")
+	}
+
+	for _, pattern := range condition.FlagKeys {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("has an invalid flag key pattern '%v'", pattern)
+		}
+	}
+
+	if condition.ValuePattern != "" {
+		regex, err := regexp.Compile(condition.ValuePattern)
+		if err != nil {
+			return fmt.Errorf("has an invalid value pattern: %v", err)
+		}
+		condition.valueRegex = regex
+	}
+
+	return validateDestination(condition.toSchema())
+}
+
+// Returns true if the override block applies to the flag in the environment being migrated
+func (o schemaOverride) matchesFlag(flag ldapi.FeatureFlag) bool {
+	if len(o.Environments) > 0 && !contains(o.Environments, envKey) {
+		return false
+	}
+
+	if len(o.Flags) > 0 {
+		for _, pattern := range o.Flags {
+			if isMatch, _ := path.Match(pattern, flag.Key); isMatch {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// Helper function to get the override blocks that apply to a flag
+func overridesForFlag(flag ldapi.FeatureFlag) []schemaOverride {
+	overrides := []schemaOverride{}
+	for _, override := range schemaOverrides {
+		if override.matchesFlag(flag) {
+			overrides = append(overrides, override)
+		}
+	}
+	return overrides
+}
+
+// Helper function to get the schema's mapping of a user attribute after applying the override blocks that match
+// the flag. Later blocks take precedence over earlier ones.
+func baseMappingForFlag(flag ldapi.FeatureFlag, userAttribute string) (attributeSchema, bool) {
+	mapping, isMapped := lookupMapping(schema, userAttribute)
+
+	for _, override := range overridesForFlag(flag) {
+		if overrideMapping, isOverridden := lookupMapping(override.Mappings, userAttribute); isOverridden {
+			mapping, isMapped = overrideMapping, true
+		} else if containsAttribute(override.Remove, userAttribute) {
+			mapping, isMapped = attributeSchema{}, false
+		}
+	}
+
+	return mapping, isMapped
+}
+
+// Helper function to get the mapping of a user attribute for a flag. The first conditional mapping whose flag
+// criteria match the flag, and that doesn't depend on values, replaces the default mapping.
+func mappingForFlag(flag ldapi.FeatureFlag, userAttribute string) (attributeSchema, bool) {
+	mapping, isMapped := baseMappingForFlag(flag, userAttribute)
+	if !isMapped {
+		return mapping, false
+	}
+
+	for _, condition := range mapping.Conditions {
+		if condition.ValuePattern == "" && condition.matchesFlag(flag) {
+			resolved := condition.toSchema()
+			resolved.FanOut = mapping.FanOut
+			return resolved, true
+		}
+	}
+
+	return mapping, true
+}
+
+// Helper function to split the values of a user attribute across its destinations. Each value goes to the first
+// conditional mapping whose value pattern matches it, or to the flag's mapping otherwise. Fan-out mappings receive
+// every value. Returns nothing if the attribute isn't mapped.
+func splitValues(flag ldapi.FeatureFlag, userAttribute string, values []interface{}) []clauseDestination {
+	primary, isMapped := mappingForFlag(flag, userAttribute)
+	if !isMapped {
+		return nil
+	}
+
+	base, _ := baseMappingForFlag(flag, userAttribute)
+	conditions := []conditionalMapping{}
+	for _, condition := range base.Conditions {
+		if condition.ValuePattern != "" && condition.matchesFlag(flag) {
+			conditions = append(conditions, condition)
+		}
+	}
+
+	split := []clauseDestination{{mapping: primary}}
+	for _, condition := range conditions {
+		split = append(split, clauseDestination{mapping: condition.toSchema()})
+	}
+	for _, value := range values {
+		i := 0
+		for j, condition := range conditions {
+			if condition.valueRegex.MatchString(fmt.Sprint(value)) {
+				i = j + 1
+				break
+			}
+		}
+		split[i].values = append(split[i].values, value)
+	}
+
+	destinations := []clauseDestination{}
+	for i, destination := range split {
+		if len(destination.values) > 0 || (i == 0 && len(values) == 0) {
+			destinations = append(destinations, destination)
+		}
+	}
+	for _, fanOut := range primary.FanOut {
+		destinations = append(destinations, clauseDestination{mapping: fanOut, values: values})
+	}
+
+	return destinations
+}
+
+// Helper function to get the destinations of a user clause. A negated clause can't be split across kinds, because
+// its copies would need to match together rather than separately, so it only uses the flag's mapping. The migration
+// warns about this, so that callers which only inspect the clause don't repeat the warning.
+func clauseDestinations(flag ldapi.FeatureFlag, clause ldapi.Clause) []clauseDestination {
+	if !clause.Negate {
+		return splitValues(flag, clause.Attribute, clause.Values)
+	}
+
+	mapping, isMapped := mappingForFlag(flag, clause.Attribute)
+	if !isMapped {
+		return nil
+	}
+	return []clauseDestination{{mapping: mapping, values: clause.Values}}
+}
+
+// Returns true if a user attribute's values may move to more than one destination for the flag
+func hasMultipleDestinations(flag ldapi.FeatureFlag, userAttribute string) bool {
+	mapping, isMapped := mappingForFlag(flag, userAttribute)
+	if !isMapped {
+		return false
+	}
+	if len(mapping.FanOut) > 0 {
+		return true
+	}
+
+	base, _ := baseMappingForFlag(flag, userAttribute)
+	for _, condition := range base.Conditions {
+		if condition.ValuePattern != "" && condition.matchesFlag(flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to get every context kind and attribute that the flag's user targeting moves to. Clauses use the same
+// destinations as the migration, and only rollouts that bucket by the user kind are counted.
+func flagDestinations(flag ldapi.FeatureFlag, details flagDetails) []attributeSchema {
+	destinations := []attributeSchema{}
+	seen := map[string]bool{}
+	add := func(mapping attributeSchema) {
+		id := mapping.Kind + canonicalAttribute(mapping.Attribute)
+		if !seen[id] {
+			seen[id] = true
+			destinations = append(destinations, mapping)
+		}
+	}
+	addRollout := func(rollout *ldapi.Rollout) {
+		if rollout == nil || (rollout.ContextKind != nil && *rollout.ContextKind != userKind) {
+			return
+		}
+		attribute := keyAttribute
+		if rollout.BucketBy != nil {
+			attribute = *rollout.BucketBy
+		}
+		if mapping, isMapped := mappingForFlag(flag, attribute); isMapped {
+			add(mapping)
+		}
+	}
+
+	for _, target := range details.targetUserRefs {
+		for _, destination := range splitValues(flag, keyAttribute, toInterfaces(target.target.Values)) {
+			add(destination.mapping)
+		}
+	}
+	for _, rule := range details.ruleUserRefs {
+		for _, clause := range rule.clauses {
+			if contains(attributesToIgnore, clause.Attribute) {
+				continue
+			}
+			for _, destination := range clauseDestinations(flag, clause) {
+				add(destination.mapping)
+			}
+		}
+		addRollout(rule.rollout)
+	}
+	addRollout(details.fallthroughRollout)
+
+	return destinations
+}
+
+func toInterfaces(values []string) []interface{} {
+	result := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		result = append(result, value)
+	}
+	return result
+}
+
+func toStrings(values []interface{}) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		str, _ := toType(value, typeString)
+		result = append(result, str.(string))
+	}
+	return result
+}
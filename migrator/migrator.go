@@ -3,12 +3,11 @@ package migrator
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
 	"strings"
-
-	"gopkg.in/yaml.v3"
+	"sync"
 
 	ldapi "github.com/launchdarkly/api-client-go/v12"
 )
@@ -22,8 +21,21 @@ var (
 	backupMaintainerTeam   string
 	schemaFile             string
 	repos                  []string
+	autoRepos              bool
+	localRepos             []localRepository
 	flagKeys               []string
 	migrate                bool
+	keyClauseTargetsMin    int
+	rewriteNegatedClauses  bool
+	blockTypeMismatches    bool
+	blockPendingChanges    bool
+	blockGuardrailErrors   bool
+	partialRulePolicy      string
+	contextsFile           string
+	reportEnvKeys          []string
+	guardrailNames         []string
+	guardrailCommand       []string
+	policyFile             string
 	schema                 map[string]attributeSchema
 	client                 *ldapi.APIClient
 	ctx                    context.Context
@@ -37,6 +49,9 @@ const (
 	defaultHost                   = "https://app.launchdarkly.com"
 	updateRuleVarOrRollout        = "updateRuleVariationOrRollout"
 	updateFallthroughVarOrRollout = "updateFallthroughVariationOrRollout"
+	partialRuleSkipFlag           = "skip-flag"
+	partialRuleSkipRule           = "skip-rule"
+	partialRuleWarn               = "warn"
 )
 
 var attributesToIgnore = []string{
@@ -54,6 +69,7 @@ type ruleInfo struct {
 	ruleId  string
 	clauses []ldapi.Clause
 	rollout *ldapi.Rollout
+	rule    ldapi.Rule
 }
 
 type member struct {
@@ -66,20 +82,29 @@ type flagDetails struct {
 	ruleUserRefs       []ruleInfo
 	fallthroughRollout *ldapi.Rollout
 	guardrailMessages  []string
+	guardrailWarnings  []string
+	pendingWarnings    []string
+	guardrailResults   []guardrailResult
+	cluster            []string
 	maintainerTeamKey  string
 	maintainerMember   member
 	maintainerStr      string
 	maintainerTypeStr  string
 }
 
-type attributeSchema struct {
-	Kind      string
-	Attribute string
+var configureOnce sync.Once
+
+// Configure reads the arguments from the environment, loads the schema and policy files, and prepares the
+// LaunchDarkly API client. Every command calls it before it runs, so library callers only need to call it to read
+// the arguments earlier. Only the first call has any effect.
+func Configure() {
+	configureOnce.Do(configure)
 }
 
-func init() {
+func configure() {
 	parseArgs()
 	prepareSchema()
+	preparePolicy()
 
 	config := ldapi.NewConfiguration()
 	config.Servers = ldapi.ServerConfigurations{
@@ -101,10 +126,6 @@ func init() {
 
 func parseArgs() {
 	apiKey = os.Getenv("LD_API_KEY")
-	if apiKey == "" {
-		log.Fatal("Must supply LD_API_KEY")
-		os.Exit(1)
-	}
 
 	projectKey = os.Getenv("LD_PROJECT")
 	if projectKey == "" {
@@ -133,6 +154,9 @@ func parseArgs() {
 	reposArg := os.Getenv("REPOSITORIES")
 	if reposArg == "" {
 		fmt.Printf("REPOSITORIES is unspecified: using default behavior where all repositories are ready\n")
+	} else if reposArg == "auto" {
+		autoRepos = true
+		fmt.Printf("REPOSITORIES is auto: local repositories where every LaunchDarkly SDK supports contexts are ready\n")
 	} else {
 		fmt.Printf("REPOSITORIES is provided: %v\n", reposArg)
 		for _, repo := range strings.Split(reposArg, ",") {
@@ -140,6 +164,26 @@ func parseArgs() {
 		}
 	}
 
+	localReposArg := os.Getenv("LOCAL_REPOSITORIES")
+	if localReposArg == "" {
+		fmt.Printf("LOCAL_REPOSITORIES is unspecified: using default behavior of reading code references from LaunchDarkly\n")
+	} else {
+		fmt.Printf("LOCAL_REPOSITORIES is provided: %v\n", localReposArg)
+		for _, entry := range strings.Split(localReposArg, ",") {
+			repo, err := parseLocalRepository(entry)
+			if err != nil {
+				log.Fatalf("LOCAL_REPOSITORIES has an invalid entry '%v': %v", entry, err)
+				os.Exit(13)
+			}
+			localRepos = append(localRepos, repo)
+		}
+	}
+
+	if autoRepos && len(localRepos) == 0 {
+		log.Fatal("REPOSITORIES is auto but LOCAL_REPOSITORIES isn't provided. LOCAL_REPOSITORIES must also be provided to find the ready repositories.")
+		os.Exit(13)
+	}
+
 	flagsArg := os.Getenv("LD_FLAGS")
 	if flagsArg == "" {
 		fmt.Printf("LD_FLAGS is unspecified: using default behavior where all flags are considered\n")
@@ -150,6 +194,17 @@ func parseArgs() {
 		}
 	}
 
+	reportEnvsArg := os.Getenv("REPORT_ENVIRONMENTS")
+	if reportEnvsArg == "" {
+		reportEnvKeys = []string{envKey}
+		fmt.Printf("REPORT_ENVIRONMENTS is unspecified: using default behavior of reporting on LD_ENVIRONMENT\n")
+	} else {
+		fmt.Printf("REPORT_ENVIRONMENTS is provided: %v\n", reportEnvsArg)
+		for _, env := range strings.Split(reportEnvsArg, ",") {
+			reportEnvKeys = append(reportEnvKeys, env)
+		}
+	}
+
 	schemaFile = os.Getenv("SCHEMA_FILE")
 	if schemaFile == "" {
 		fmt.Printf("SCHEMA_FILE is unspecified: using default behavior of having no schema\n")
@@ -169,63 +224,143 @@ func parseArgs() {
 		os.Exit(2)
 	}
 
-	backupMaintainerTeam = os.Getenv("BACKUP_MAINTAINER_TEAM")
-	if backupMaintainerTeam == "" {
-		fmt.Printf("BACKUP_MAINTAINER_TEAM is unspecified: checking to see if BACKUP_MAINTAINER_MEMBER is specified\n")
-
-		backupMaintainerMember = os.Getenv("BACKUP_MAINTAINER_MEMBER")
-		if backupMaintainerMember == "" {
-			fmt.Printf("BACKUP_MAINTAINER_MEMBER is unspecified: using default behavior of having no backup maintainer\n")
-		} else {
-			fmt.Printf("BACKUP_MAINTAINER_MEMBER is provided: %v\n", backupMaintainerMember)
+	keyClausesArg := os.Getenv("KEY_CLAUSES_TO_TARGETS")
+	if keyClausesArg == "" {
+		fmt.Printf("KEY_CLAUSES_TO_TARGETS is unspecified: using default behavior of rewriting key clauses\n")
+	} else {
+		minKeys, err := strconv.Atoi(keyClausesArg)
+		if err != nil || minKeys < 1 {
+			log.Fatal("KEY_CLAUSES_TO_TARGETS must be a positive number of keys.")
+			os.Exit(7)
 		}
+		keyClauseTargetsMin = minKeys
+		fmt.Printf("KEY_CLAUSES_TO_TARGETS is provided: rules with a single key clause of at least %v key(s) will become individual targets\n", keyClauseTargetsMin)
+	}
+
+	if os.Getenv("NEGATED_CLAUSE_REWRITE") == "" {
+		fmt.Printf("NEGATED_CLAUSE_REWRITE is unspecified: using default behavior of only reporting negated clauses that change context kind\n")
 	} else {
-		fmt.Printf("BACKUP_MAINTAINER_TEAM is provided: %v\n", backupMaintainerTeam)
+		rewriteNegatedClauses = true
+		fmt.Printf("NEGATED_CLAUSE_REWRITE is provided: rules will be added to keep the behavior of negated clauses that change context kind\n")
 	}
 
-	fmt.Println()
-}
+	if os.Getenv("BLOCK_TYPE_MISMATCHES") == "" {
+		fmt.Printf("BLOCK_TYPE_MISMATCHES is unspecified: using default behavior of only reporting clauses that don't suit their destination's type\n")
+	} else {
+		blockTypeMismatches = true
+		fmt.Printf("BLOCK_TYPE_MISMATCHES is provided: flags with clauses that don't suit their destination's type won't be migrated\n")
+	}
 
-func prepareSchema() {
-	if schemaFile == "" {
-		return
+	if os.Getenv("BLOCK_PENDING_CHANGES") == "" {
+		fmt.Printf("BLOCK_PENDING_CHANGES is unspecified: using default behavior of only reporting scheduled changes and approval requests that change user targeting\n")
+	} else {
+		blockPendingChanges = true
+		fmt.Printf("BLOCK_PENDING_CHANGES is provided: flags with scheduled changes or approval requests that change user targeting won't be migrated\n")
 	}
 
-	// Read the schema file provided by the arguments
+	partialRulePolicy = os.Getenv("PARTIAL_RULE_POLICY")
+	if partialRulePolicy == "" {
+		partialRulePolicy = partialRuleWarn
+		fmt.Printf("PARTIAL_RULE_POLICY is unspecified: using default value of %v\n", partialRuleWarn)
+	} else if partialRulePolicy == partialRuleSkipFlag || partialRulePolicy == partialRuleSkipRule || partialRulePolicy == partialRuleWarn {
+		fmt.Printf("PARTIAL_RULE_POLICY is provided: %v\n", partialRulePolicy)
+	} else {
+		log.Fatalf("PARTIAL_RULE_POLICY must be one of %v, %v or %v.", partialRuleSkipFlag, partialRuleSkipRule, partialRuleWarn)
+		os.Exit(8)
+	}
 
-	file, err := ioutil.ReadFile(schemaFile)
+	contextsFile = os.Getenv("CONTEXTS_FILE")
+	if contextsFile == "" {
+		fmt.Printf("CONTEXTS_FILE is unspecified: using default behavior of sampling contexts from LaunchDarkly when needed\n")
+	} else {
+		fmt.Printf("CONTEXTS_FILE is provided: %v\n", contextsFile)
+	}
 
-	if err != nil {
-		log.Fatal(err)
-		os.Exit(3)
+	guardrailsArg := os.Getenv("GUARDRAILS")
+	if guardrailsArg == "" {
+		fmt.Printf("GUARDRAILS is unspecified: using default behavior of running every registered guardrail\n")
+	} else {
+		fmt.Printf("GUARDRAILS is provided: %v\n", guardrailsArg)
+		for _, name := range strings.Split(guardrailsArg, ",") {
+			guardrailNames = append(guardrailNames, name)
+		}
+	}
+
+	if os.Getenv("GUARDRAIL_ERRORS") == "block" {
+		blockGuardrailErrors = true
+		fmt.Printf("GUARDRAIL_ERRORS is block: flags that a guardrail can't check won't be migrated\n")
+	} else if os.Getenv("GUARDRAIL_ERRORS") == "" {
+		fmt.Printf("GUARDRAIL_ERRORS is unspecified: using default behavior where only the guardrails that fail closed block flags they can't check\n")
+	} else {
+		log.Fatal("GUARDRAIL_ERRORS must be block.")
+		os.Exit(14)
 	}
 
-	schema = make(map[string]attributeSchema)
-	err = yaml.Unmarshal(file, &schema)
+	guardrailCommandArg := os.Getenv("GUARDRAIL_COMMAND")
+	if guardrailCommandArg == "" {
+		fmt.Printf("GUARDRAIL_COMMAND is unspecified: using default behavior of having no external guardrail\n")
+	} else {
+		fmt.Printf("GUARDRAIL_COMMAND is provided: %v\n", guardrailCommandArg)
+		command, err := splitCommandLine(guardrailCommandArg)
+		if err != nil {
+			log.Fatalf("GUARDRAIL_COMMAND can't be split into an executable and its arguments because %v.", err)
+			os.Exit(12)
+		} else if len(command) == 0 {
+			log.Fatal("GUARDRAIL_COMMAND must name an executable.")
+			os.Exit(12)
+		}
+		guardrailCommand = command
+	}
 
-	if err != nil {
-		log.Fatal(err)
-		os.Exit(4)
+	policyFile = os.Getenv("POLICY_FILE")
+	if policyFile == "" {
+		fmt.Printf("POLICY_FILE is unspecified: using default behavior of having no policy rules\n")
+	} else {
+		fmt.Printf("POLICY_FILE is provided: %v\n", policyFile)
 	}
 
-	// Print the schema
+	backupMaintainerTeam = os.Getenv("BACKUP_MAINTAINER_TEAM")
+	if backupMaintainerTeam == "" {
+		fmt.Printf("BACKUP_MAINTAINER_TEAM is unspecified: checking to see if BACKUP_MAINTAINER_MEMBER is specified\n")
 
-	fmt.Println("Using the following schema mappings:")
-	for userAttribute, newAttribute := range schema {
-		fmt.Printf("  %s: %s\n", userAttribute, newAttribute)
+		backupMaintainerMember = os.Getenv("BACKUP_MAINTAINER_MEMBER")
+		if backupMaintainerMember == "" {
+			fmt.Printf("BACKUP_MAINTAINER_MEMBER is unspecified: using default behavior of having no backup maintainer\n")
+		} else {
+			fmt.Printf("BACKUP_MAINTAINER_MEMBER is provided: %v\n", backupMaintainerMember)
+		}
+	} else {
+		fmt.Printf("BACKUP_MAINTAINER_TEAM is provided: %v\n", backupMaintainerTeam)
 	}
 
 	fmt.Println()
 }
 
+// Exits unless an API key was supplied. Commands that call the LaunchDarkly API must call this first.
+func requireAPIKey() {
+	if apiKey == "" {
+		log.Fatal("Must supply LD_API_KEY")
+		os.Exit(1)
+	}
+}
+
 func Migrate() {
-	// Get all feature flags for this project and environment
-	flags, r, err := client.FeatureFlagsApi.GetFeatureFlags(ctx, projectKey).Env(envKey).Summary(false).Execute()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error when calling `FeatureFlagsApi.GetFeatureFlags``: %v\n", err)
-		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
-		os.Exit(5)
+	Configure()
+	requireAPIKey()
+	enabledGuardrails() // Exits early if GUARDRAILS names an unknown guardrail
+
+	if autoRepos {
+		repos = readyRepositories()
+		if len(repos) == 0 {
+			fmt.Printf("No local repositories are ready, so flags referenced in any repository won't be migrated.\n")
+		} else {
+			fmt.Printf("Ready repositories: %v\n", strings.Join(repos, ", "))
+		}
+		fmt.Println()
 	}
+
+	// Get all feature flags for this project and environment
+	flags := getFlags(envKey)
 	fmt.Printf("Inspecting flags for project '%v' and environment '%v'.\n", projectKey, envKey)
 
 	numFound := 0
@@ -233,55 +368,209 @@ func Migrate() {
 	numGuardrail := 0
 	numNotNeeded := 0
 	numInstAdded := 0
+	numScheduled := 0
+	numWorkflows := 0
+	numScheduledFlags := 0
+	numBlockedBy := map[string]int{}
 
 	safeToMigrateBonusText := ""
 
 	// For each flag, determine if it needs to be migrated and if it is safe to do so.
+	selected := []ldapi.FeatureFlag{}
+	allDetails := map[string]flagDetails{}
 	for _, flag := range flags.Items {
 		if len(flagKeys) == 0 || contains(flagKeys, flag.Key) {
-			numFound++
-			details := inspectFlag(flag)
+			selected = append(selected, flag)
+			allDetails[flag.Key] = inspectFlag(flag)
+		}
+	}
 
-			if len(details.guardrailMessages) > 0 {
-				numGuardrail++
-			} else if isFlagTargetingUsers(details) {
-				numMigrateReady++
-			} else {
-				numNotNeeded++
+	// Flags connected by prerequisites are only safe to migrate together, so the guardrails that check prerequisite
+	// clusters run once every selected flag has been inspected
+	graph := buildPrerequisiteGraph(flags.Items, envKey)
+	prepareClusters(graph, allDetails)
+	for _, flag := range selected {
+		details := allDetails[flag.Key]
+		if isFlagTargetingUsers(details) {
+			for _, result := range runGuardrails(flag, true) {
+				details.addGuardrailResult(result)
 			}
+			allDetails[flag.Key] = details
+		}
+	}
+
+	numPartialClusters := 0
+	for _, flag := range migrationOrder(selected, graph) {
+		// The approvals of a prerequisite cluster are submitted once all of its flags have been prepared
+		if len(queuedClusterApprovals) > 0 && !inQueuedCluster(flag.Key) && submitClusterApprovals() {
+			numPartialClusters++
+		}
 
-			if isFlagTargetingUsers(details) && len(details.guardrailMessages) == 0 && len(schema) > 0 {
-				// Prepare an approval for migrating this flag
-				numInstAdded += prepareApproval(flag, details)
-				if migrate {
-					safeToMigrateBonusText = " Approval(s) have been submitted to the flag maintainers for review."
+		numFound++
+		details := allDetails[flag.Key]
+		printFlagStatus(flag, details)
+
+		if len(details.guardrailMessages) > 0 {
+			numGuardrail++
+			for _, result := range details.guardrailResults {
+				if result.blocks() {
+					numBlockedBy[result.name]++
 				}
 			}
+		} else if isFlagTargetingUsers(details) {
+			numMigrateReady++
+		} else {
+			numNotNeeded++
 		}
+
+		numFlagScheduled := 0
+		numFlagWorkflows := 0
+		if isFlagTargetingUsers(details) && len(details.guardrailMessages) == 0 && len(schema) > 0 {
+			// Prepare an approval for migrating this flag and its scheduled changes, and migrate its workflows
+			numInstAdded += prepareApproval(flag, details)
+			numFlagScheduled = migrateScheduledChanges(flag, details)
+			numFlagWorkflows = migrateWorkflows(flag, details)
+			if migrate {
+				safeToMigrateBonusText = " Approval(s) have been submitted to the flag maintainers for review."
+			}
+		} else if !isFlagTargetingUsers(details) {
+			// Scheduled changes and workflows can add user targeting to flags that don't target users today
+			numFlagScheduled, numFlagWorkflows = checkUpcomingUserTargeting(flag, details)
+		}
+		if numFlagScheduled > 0 || numFlagWorkflows > 0 {
+			numScheduled += numFlagScheduled
+			numWorkflows += numFlagWorkflows
+			numScheduledFlags++
+		}
+	}
+	if len(queuedClusterApprovals) > 0 && submitClusterApprovals() {
+		numPartialClusters++
 	}
 
 	fmt.Println()
 	fmt.Printf("%v flag(s) found.\n", numFound)
 	fmt.Printf(" - %v flag(s) contain user targeting and are safe to migrate.%v\n", numMigrateReady, safeToMigrateBonusText)
 	fmt.Printf(" - %v flag(s) aren't safe to migrate per the specified guardrails.\n", numGuardrail)
+	blockingGuardrails := []string{}
+	for _, guardrail := range enabledGuardrails() {
+		blockingGuardrails = append(blockingGuardrails, guardrail.Name())
+	}
+	for _, name := range blockingGuardrails {
+		if numBlockedBy[name] > 0 {
+			fmt.Printf("   - %v flag(s) failed or couldn't be checked by the '%v' guardrail.\n", numBlockedBy[name], name)
+		}
+	}
 	fmt.Printf(" - %v flag(s) do not need to be migrated.\n", numNotNeeded)
+	if numPartialClusters > 0 {
+		fmt.Printf(" - %v prerequisite cluster(s) were only partially submitted. Their approvals shouldn't be applied until the rest are submitted.\n", numPartialClusters)
+	}
 
 	if len(schema) > 0 {
 		fmt.Println()
 		if migrate {
 			fmt.Printf("This migration script automated %v change(s) across %v flag(s).\n", numInstAdded, numMigrateReady)
+			fmt.Printf("It migrated %v scheduled change(s) and %v workflow(s) across %v flag(s).\n", numScheduled, numWorkflows, numScheduledFlags)
 		} else {
 			fmt.Printf("This migration script would have automated %v change(s) across %v flag(s).\n", numInstAdded, numMigrateReady)
+			fmt.Printf("It would have migrated %v scheduled change(s) and %v workflow(s) across %v flag(s).\n", numScheduled, numWorkflows, numScheduledFlags)
 		}
 	}
 }
 
+// Get all feature flags for this project with their configuration in the given environment
+func getFlags(env string) *ldapi.FeatureFlags {
+	flags, r, err := client.FeatureFlagsApi.GetFeatureFlags(ctx, projectKey).Env(env).Summary(false).Execute()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when calling `FeatureFlagsApi.GetFeatureFlags``: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
+		os.Exit(5)
+	}
+	return flags
+}
+
 // Returns true if the flag targets users anywhere in the flag configuration
 func isFlagTargetingUsers(details flagDetails) bool {
 	return len(details.targetUserRefs) > 0 || len(details.ruleUserRefs) > 0 || details.fallthroughRollout != nil
 }
 
+// Identify the flag's user targeting and maintainer and, if it targets users, the results of the guardrails. Every
+// flag gets a maintainer, since the scheduled changes of flags that don't target users can still be migrated.
 func inspectFlag(flag ldapi.FeatureFlag) flagDetails {
+	details := userReferences(flag)
+
+	maintainerTeamKey, maintainerMemberId, maintainerMemberEmail := getMaintainer(flag)
+	details.maintainerTeamKey = maintainerTeamKey
+	details.maintainerMember = member{maintainerMemberEmail, maintainerMemberId}
+	details.maintainerTypeStr = "undefined"
+	details.maintainerStr = "n/a"
+	if details.maintainerMember.email != "" {
+		details.maintainerTypeStr = "member"
+		details.maintainerStr = details.maintainerMember.email
+	} else if details.maintainerTeamKey != "" {
+		details.maintainerTypeStr = "team"
+		details.maintainerStr = details.maintainerTeamKey
+	} else if backupMaintainerMember != "" {
+		details.maintainerTypeStr = "backup member"
+		details.maintainerStr = backupMaintainerMember
+	} else if backupMaintainerTeam != "" {
+		details.maintainerTypeStr = "backup team"
+		details.maintainerStr = backupMaintainerTeam
+	}
+
+	if isFlagTargetingUsers(details) {
+		for _, result := range runGuardrails(flag, false) {
+			details.addGuardrailResult(result)
+		}
+		details.pendingWarnings = pendingChangeWarnings(ctx, flag)
+	}
+
+	return details
+}
+
+// Record the outcome of a guardrail, along with a message for each of its violations or its error
+func (details *flagDetails) addGuardrailResult(result guardrailResult) {
+	details.guardrailResults = append(details.guardrailResults, result)
+	for _, violation := range result.violations {
+		details.guardrailMessages = append(details.guardrailMessages, "  "+violation)
+	}
+	if result.err != nil {
+		message := fmt.Sprintf("  The '%v' guardrail couldn't check the flag: %v.", result.name, result.err)
+		if result.failClosed {
+			details.guardrailMessages = append(details.guardrailMessages, message)
+		} else {
+			details.guardrailWarnings = append(details.guardrailWarnings, message)
+		}
+	}
+}
+
+// If the flag is targeting the user context kind anywhere, print whether it's safe to migrate
+func printFlagStatus(flag ldapi.FeatureFlag, details flagDetails) {
+	if !isFlagTargetingUsers(details) {
+		return
+	}
+
+	if len(details.guardrailMessages) > 0 {
+		fmt.Printf("Flag '%v' isn't safe to be migrated because:\n", flag.Key)
+		for _, msg := range details.guardrailMessages {
+			fmt.Println(msg)
+		}
+	} else {
+		fmt.Printf("Flag '%v' is safe to be migrated by the %v maintainer (%v).\n", flag.Key, details.maintainerTypeStr, details.maintainerStr)
+	}
+	for _, msg := range details.guardrailWarnings {
+		fmt.Println(msg)
+	}
+	for _, msg := range details.pendingWarnings {
+		fmt.Println(msg)
+	}
+	printGuardrailResults(details.guardrailResults)
+	if len(details.cluster) > 0 {
+		fmt.Printf("  Prerequisite cluster: migrated together with %v\n", strings.Join(details.cluster, ", "))
+	}
+}
+
+// Identify the individual targets, rules and fallthrough rollout of the flag that refer to the user context kind
+func userReferences(flag ldapi.FeatureFlag) flagDetails {
 	flagConfig := flag.Environments[envKey]
 	details := flagDetails{}
 
@@ -302,7 +591,7 @@ func inspectFlag(flag ldapi.FeatureFlag) flagDetails {
 		}
 
 		if len(clauses) > 0 || rule.Rollout != nil {
-			details.ruleUserRefs = append(details.ruleUserRefs, ruleInfo{*rule.Id, clauses, rule.Rollout})
+			details.ruleUserRefs = append(details.ruleUserRefs, ruleInfo{*rule.Id, clauses, rule.Rollout, rule})
 		}
 	}
 
@@ -314,89 +603,96 @@ func inspectFlag(flag ldapi.FeatureFlag) flagDetails {
 		}
 	}
 
-	// If the flag is targeting the user context kind anywhere above, print information about it
-	if isFlagTargetingUsers(details) {
-		maintainerTeamKey, maintainerMemberId, maintainerMemberEmail := getMaintainer(flag)
-		details.maintainerTeamKey = maintainerTeamKey
-		details.maintainerMember = member{maintainerMemberEmail, maintainerMemberId}
-		details.guardrailMessages = getPreconditionViolations(flag)
-
-		if len(details.guardrailMessages) > 0 {
-			fmt.Printf("Flag '%v' isn't safe to be migrated because:\n", flag.Key)
-			for _, msg := range details.guardrailMessages {
-				fmt.Println(msg)
-			}
-		} else {
-			details.maintainerTypeStr = "undefined"
-			details.maintainerStr = "n/a"
-			if details.maintainerMember.email != "" {
-				details.maintainerTypeStr = "member"
-				details.maintainerStr = details.maintainerMember.email
-			} else if details.maintainerTeamKey != "" {
-				details.maintainerTypeStr = "team"
-				details.maintainerStr = details.maintainerTeamKey
-			} else if backupMaintainerMember != "" {
-				details.maintainerTypeStr = "backup member"
-				details.maintainerStr = backupMaintainerMember
-			} else if backupMaintainerTeam != "" {
-				details.maintainerTypeStr = "backup team"
-				details.maintainerStr = backupMaintainerTeam
-			}
-			fmt.Printf("Flag '%v' is safe to be migrated by the %v maintainer (%v).\n", flag.Key, details.maintainerTypeStr, details.maintainerStr)
-		}
-	}
-
 	return details
 }
 
 func prepareApproval(flag ldapi.FeatureFlag, details flagDetails) int {
 	instructions := []map[string]interface{}{}
 
+	if overrides := overridesForFlag(flag); len(overrides) > 0 {
+		fmt.Printf("  Using %v schema override block(s) for this flag.\n", len(overrides))
+	}
+
 	// Add instructions to migrate individual targets
 	for _, target := range details.targetUserRefs {
-		mapping, isMapped := schema[keyAttribute]
+		destinations := splitValues(flag, keyAttribute, toInterfaces(target.target.Values))
 
-		if isMapped {
-			instructions = append(instructions, map[string]interface{}{
-				"kind":        interface{}("addTargets"),
-				"contextKind": interface{}(mapping.Kind),
-				"values":      interface{}(target.target.Values),
-				"variationId": interface{}(target.variation.Id),
-			})
-			instructions = append(instructions, map[string]interface{}{
-				"kind":        interface{}("removeTargets"),
-				"contextKind": interface{}(userKind),
-				"values":      interface{}(target.target.Values),
-				"variationId": interface{}(target.variation.Id),
-			})
-			fmt.Printf("  Adding instructions to replace individual user targets with individual '%v' targets.\n", schema[keyAttribute].Kind)
-		} else {
+		if len(destinations) == 0 {
 			fmt.Printf("  Skipping individual user targets because no '%v' mapping was provided.\n", keyAttribute)
+			continue
 		}
+
+		for _, destination := range destinations {
+			mapping := destination.mapping
+
+			if sameAttribute(mapping.Attribute, keyAttribute) {
+				keys := transformKeys(keyAttribute, toStrings(destination.values), mapping)
+				instructions = append(instructions, map[string]interface{}{
+					"kind":        interface{}("addTargets"),
+					"contextKind": interface{}(mapping.Kind),
+					"values":      interface{}(keys),
+					"variationId": interface{}(target.variation.Id),
+				})
+				fmt.Printf("  Adding instructions to replace individual user targets with individual '%v' targets.\n", mapping.Kind)
+			} else {
+				// Individual targets can only hold context keys, so the targets become a rule instead. Unlike keys, the
+				// rule's values can have the type that the schema declares for the attribute.
+				values := toDeclaredType(transformValues(keyAttribute, "in", destination.values, mapping), mapping)
+				instructions = append(instructions, targetsToRule(flag, target.variation, values, mapping))
+				fmt.Printf("  Adding instructions to replace individual user targets with a targeting rule for '%v' attribute '%v'.\n", mapping.Kind, mapping.Attribute)
+				reportTypeProblems(keyAttribute, "in", values, mapping)
+			}
+		}
+		instructions = append(instructions, map[string]interface{}{
+			"kind":        interface{}("removeTargets"),
+			"contextKind": interface{}(userKind),
+			"values":      interface{}(target.target.Values),
+			"variationId": interface{}(target.variation.Id),
+		})
 	}
 
+	// Add instructions to convert leading key clause rules into individual targets
+	convertedRuleIds, convertInstructions := keyClauseRulesToTargets(flag, details)
+	instructions = append(instructions, convertInstructions...)
+
 	// Add instructions to migrate rules
 	for _, rule := range details.ruleUserRefs {
-		// Rule clauses
-		toAdd, toRemove := toInstructionClauses(rule.clauses)
-		if len(toAdd) > 0 && len(toRemove) > 0 {
-			instructions = append(instructions, map[string]interface{}{
-				"kind":    interface{}("addClauses"),
-				"ruleId":  interface{}(rule.ruleId),
-				"clauses": toAdd,
-			})
-			instructions = append(instructions, map[string]interface{}{
-				"kind":      interface{}("removeClauses"),
-				"ruleId":    interface{}(rule.ruleId),
-				"clauseIds": toRemove,
-			})
+		if convertedRuleIds[rule.ruleId] {
+			continue
+		}
+
+		// Report the context kinds the rule's clauses will combine after the migration
+		kinds, partial := ruleKinds(flag, rule.rule)
+		if len(kinds) > 0 {
+			fmt.Printf("  After the migration, %v will match on context kind(s): %v.\n", ruleLabel(flag, rule.rule), strings.Join(kinds, " + "))
+		}
+		if partial && skipPartialRule(ruleLabel(flag, rule.rule)) {
+			continue
 		}
 
+		// Rule clauses
+		instructions = append(instructions, toInstructionClauses(flag, rule.rule)...)
+
 		// Rule rollouts
 		instruction := handleRollout(flag, rule.rollout, &rule.ruleId)
 		if instruction != nil {
 			instructions = append(instructions, *instruction)
 		}
+
+		// Negated clauses that move to another context kind
+		instruction = handleNegatedClauses(flag, rule.rule)
+		if instruction != nil {
+			instructions = append(instructions, *instruction)
+		}
+	}
+
+	// The secondary attribute used to take part in bucketing, so rollouts may bucket some contexts differently
+	hasRollouts := details.fallthroughRollout != nil
+	for _, rule := range details.ruleUserRefs {
+		hasRollouts = hasRollouts || rule.rollout != nil
+	}
+	if hasRollouts && referencesSecondary(flag, details) {
+		fmt.Printf("  Note: users with a 'secondary' attribute may be bucketed differently in this flag's rollouts, because 'secondary' no longer affects bucketing.\n")
 	}
 
 	// Add instructions to migrate fallthrough rollouts
@@ -410,31 +706,13 @@ func prepareApproval(flag ldapi.FeatureFlag, details flagDetails) int {
 	if migrate {
 		if len(instructions) > 0 {
 			description := "Migrating " + flag.Key + " to use custom contexts."
-			req := *ldapi.NewCreateFlagConfigApprovalRequestRequest(description, instructions)
-
-			// Add the maintainer
-			if details.maintainerMember.id != "" {
-				req.NotifyMemberIds = []string{details.maintainerMember.id}
-			} else if details.maintainerTeamKey != "" {
-				req.NotifyTeamKeys = []string{details.maintainerTeamKey}
-			} else if backupMaintainerMember != "" {
-				req.NotifyMemberIds = []string{backupMaintainerMember}
-			} else if backupMaintainerTeam != "" {
-				req.NotifyTeamKeys = []string{backupMaintainerTeam}
-			}
-
-			// POST the approval request to LaunchDarkly
-			_, r, err := client.ApprovalsApi.PostApprovalRequestForFlag(ctx, projectKey, flag.Key, envKey).CreateFlagConfigApprovalRequestRequest(req).Execute()
-			if r.StatusCode == 403 {
-				// The customer doesn't have access to approvals.
-				fmt.Fprint(os.Stderr, "Failed to create an approval. Either your API key lacks sufficient permission or your LaunchDarkly plan doesn't include access to approvals. Update your API key or use the script in dry-run mode.\n")
-				os.Exit(6)
-			}
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error when calling `FeatureFlagsBetaApi.GetDependentFlagsByEnv``: %v\n", err)
-				fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
+			if len(details.cluster) > 0 {
+				// Flags that share prerequisites must be migrated together, so their approvals are submitted together
+				// and a comment on each links to the others
+				description += " Apply this together with the migration approvals for the flags it shares prerequisites with: " + strings.Join(details.cluster, ", ") + "."
+				queuedClusterApprovals = append(queuedClusterApprovals, clusterApproval{flag, details, description, instructions})
 			} else {
-				fmt.Printf("  An approval request has been submitted to %v maintainer '%v' for flag '%v'!\n", details.maintainerTypeStr, details.maintainerStr, flag.Key)
+				submitApproval(flag, details, description, instructions, nil)
 			}
 		} else {
 			fmt.Printf("  Skipping the approval for flag '%v' because no mappings were provided.\n", flag.Key)
@@ -445,6 +723,194 @@ func prepareApproval(flag ldapi.FeatureFlag, details flagDetails) int {
 	return len(instructions)
 }
 
+// Submit an approval request for the flag to its maintainer. The approval edits a scheduled change instead of the
+// flag's targeting when operatingOnId is the scheduled change's ID. Returns the approval's ID, and false if it couldn't
+// be submitted.
+func submitApproval(flag ldapi.FeatureFlag, details flagDetails, description string, instructions []map[string]interface{}, operatingOnId *string) (string, bool) {
+	req := *ldapi.NewCreateFlagConfigApprovalRequestRequest(description, instructions)
+	req.OperatingOnId = operatingOnId
+
+	// Add the maintainer
+	req.NotifyMemberIds, req.NotifyTeamKeys = approvalReviewers(details)
+
+	// POST the approval request to LaunchDarkly
+	approval, r, err := client.ApprovalsApi.PostApprovalRequestForFlag(ctx, projectKey, flag.Key, envKey).CreateFlagConfigApprovalRequestRequest(req).Execute()
+	if r != nil && r.StatusCode == 403 {
+		// The customer doesn't have access to approvals.
+		fmt.Fprint(os.Stderr, "Failed to create an approval. Either your API key lacks sufficient permission or your LaunchDarkly plan doesn't include access to approvals. Update your API key or use the script in dry-run mode.\n")
+		os.Exit(6)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when calling `ApprovalsApi.PostApprovalRequestForFlag`: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
+		return "", false
+	}
+	fmt.Printf("  An approval request (%v) has been submitted to %v maintainer '%v' for flag '%v'!\n", approval.Id, details.maintainerTypeStr, details.maintainerStr, flag.Key)
+	return approval.Id, true
+}
+
+// Helper function to get the member IDs or team keys to request a review from for the flag's approvals: its
+// maintainer, or else the backup maintainer
+func approvalReviewers(details flagDetails) ([]string, []string) {
+	if details.maintainerMember.id != "" {
+		return []string{details.maintainerMember.id}, nil
+	} else if details.maintainerTeamKey != "" {
+		return nil, []string{details.maintainerTeamKey}
+	} else if backupMaintainerMember != "" {
+		return []string{backupMaintainerMember}, nil
+	} else if backupMaintainerTeam != "" {
+		return nil, []string{backupMaintainerTeam}
+	}
+	return nil, nil
+}
+
+// Construct an instruction that replaces an individual targets list with a targeting rule. The rule is placed
+// ahead of all existing rules so that the targeted contexts keep their priority over the other rules.
+func targetsToRule(flag ldapi.FeatureFlag, variation ldapi.Variation, values []interface{}, mapping attributeSchema) map[string]interface{} {
+	instruction := map[string]interface{}{
+		"kind":        interface{}("addRule"),
+		"description": interface{}("Migrated from individual user targets"),
+		"variationId": interface{}(variation.Id),
+		"clauses": []map[string]interface{}{
+			{
+				"attribute":   interface{}(emittedAttribute(mapping.Attribute)),
+				"contextKind": interface{}(mapping.Kind),
+				"negate":      interface{}(false),
+				"op":          interface{}("in"),
+				"values":      interface{}(values),
+			},
+		},
+	}
+
+	rules := flag.Environments[envKey].Rules
+	if len(rules) > 0 {
+		instruction["beforeRuleId"] = interface{}(rules[0].Id)
+	}
+
+	return instruction
+}
+
+// Construct instructions that replace rules consisting of a single large `key in [...]` clause with individual
+// targets for the context kind that the user key maps to. Individual targets are evaluated before all rules, so
+// only an unbroken run of such rules from the top of the rule list can be converted without changing priority.
+func keyClauseRulesToTargets(flag ldapi.FeatureFlag, details flagDetails) (map[string]bool, []map[string]interface{}) {
+	convertedRuleIds := map[string]bool{}
+	instructions := []map[string]interface{}{}
+
+	// Keys that are split across several kinds are left as rule clauses
+	mapping, isMapped := mappingForFlag(flag, keyAttribute)
+	if keyClauseTargetsMin == 0 || !isMapped || !sameAttribute(mapping.Attribute, keyAttribute) || hasMultipleDestinations(flag, keyAttribute) {
+		return convertedRuleIds, instructions
+	}
+
+	// Track the keys that are already individually targeted for the mapped kind, including the user targets that
+	// are being migrated, since those take priority over any rule.
+	targeted := map[string]bool{}
+	counts := map[int32]int{}
+	addTargeted := func(variation int32, values []string) {
+		for _, value := range values {
+			if !targeted[value] {
+				targeted[value] = true
+				counts[variation]++
+			}
+		}
+	}
+	for _, target := range flag.Environments[envKey].ContextTargets {
+		if target.ContextKind != nil && *target.ContextKind == mapping.Kind {
+			addTargeted(target.Variation, target.Values)
+		}
+	}
+	for _, target := range details.targetUserRefs {
+		addTargeted(target.target.Variation, transformKeys(keyAttribute, target.target.Values, mapping))
+	}
+
+	for _, rule := range flag.Environments[envKey].Rules {
+		values, ok := keyClauseValues(rule)
+		if !ok {
+			break
+		}
+		if rule.TrackEvents {
+			// Individual targets can't track events, so converting the rule would silently stop its event tracking
+			fmt.Printf("  Keeping %v and the rules below it as rule clauses because it tracks events, which individual targets can't do.\n", ruleLabel(flag, rule))
+			break
+		}
+		if rule.Description != nil && *rule.Description != "" {
+			fmt.Printf("  Note: the description of %v isn't kept, because individual targets don't have descriptions.\n", ruleLabel(flag, rule))
+		}
+
+		// Keys that are already targeted could never have matched this rule, so they aren't carried over
+		toAdd := []string{}
+		for _, value := range transformKeys(keyAttribute, values, mapping) {
+			if !targeted[value] {
+				targeted[value] = true
+				toAdd = append(toAdd, value)
+			}
+		}
+
+		if len(toAdd) > 0 {
+			instructions = append(instructions, map[string]interface{}{
+				"kind":        interface{}("addTargets"),
+				"contextKind": interface{}(mapping.Kind),
+				"values":      interface{}(toAdd),
+				"variationId": interface{}(flag.Variations[*rule.Variation].Id),
+			})
+		}
+		instructions = append(instructions, map[string]interface{}{
+			"kind":   interface{}("removeRule"),
+			"ruleId": interface{}(rule.Id),
+		})
+		counts[*rule.Variation] += len(toAdd)
+		convertedRuleIds[*rule.Id] = true
+		fmt.Printf("  Adding instructions to replace a rule with %v user key(s) with individual '%v' targets.\n", len(values), mapping.Kind)
+	}
+
+	if len(convertedRuleIds) > 0 {
+		fmt.Printf("  Individual '%v' targets after the migration:\n", mapping.Kind)
+		for i := range flag.Variations {
+			if counts[int32(i)] > 0 {
+				fmt.Printf("    %v: %v target(s)\n", variationLabel(flag, int32(i)), counts[int32(i)])
+			}
+		}
+	}
+
+	return convertedRuleIds, instructions
+}
+
+// Helper function to get the keys of a rule that serves a single variation to a single `key in [...]` user clause
+func keyClauseValues(rule ldapi.Rule) ([]string, bool) {
+	if rule.Variation == nil || len(rule.Clauses) != 1 {
+		return nil, false
+	}
+
+	clause := rule.Clauses[0]
+	if clause.ContextKind == nil || *clause.ContextKind != userKind || !sameAttribute(clause.Attribute, keyAttribute) || clause.Op != "in" || clause.Negate {
+		return nil, false
+	}
+	if len(clause.Values) < keyClauseTargetsMin {
+		return nil, false
+	}
+
+	values := make([]string, 0, len(clause.Values))
+	for _, value := range clause.Values {
+		str, isStr := value.(string)
+		if !isStr {
+			return nil, false
+		}
+		values = append(values, str)
+	}
+
+	return values, true
+}
+
+// Helper function to get a readable name for a flag variation
+func variationLabel(flag ldapi.FeatureFlag, index int32) string {
+	variation := flag.Variations[index]
+	if variation.Name != nil && *variation.Name != "" {
+		return fmt.Sprintf("Variation '%v'", *variation.Name)
+	}
+	return fmt.Sprintf("Variation %v (%v)", index, variation.Value)
+}
+
 // Construct an instruction to migrate a rollout
 func handleRollout(flag ldapi.FeatureFlag, rollout *ldapi.Rollout, ruleId *string) *map[string]interface{} {
 	if rollout == nil {
@@ -458,159 +924,396 @@ func handleRollout(flag ldapi.FeatureFlag, rollout *ldapi.Rollout, ruleId *strin
 		instructionKind = updateFallthroughVarOrRollout
 	}
 
+	contextKind, bucketBy, moved := migrateRollout(flag, rolloutType, rollout.ContextKind, rollout.BucketBy)
+	if !moved {
+		return nil
+	}
+
+	instruction := map[string]interface{}{
+		"kind":               interface{}(instructionKind),
+		"rolloutContextKind": interface{}(contextKind),
+		"rolloutBucketBy":    interface{}(bucketBy),
+		"rolloutWeights":     toRolloutWeights(flag, rollout.Variations),
+	}
+	if ruleId != nil {
+		instruction["ruleId"] = interface{}(ruleId)
+	}
+	return &instruction
+}
+
+// Helper function to get the context kind and bucketing attribute that a rollout moves to. Rollouts for other context
+// kinds, and rollouts whose bucketing attribute isn't mapped, keep their own, and moved is false for them.
+func rolloutDestination(flag ldapi.FeatureFlag, contextKind *string, bucketBy *string) (string, string, bool) {
+	kind := userKind
+	if contextKind != nil && *contextKind != "" {
+		kind = *contextKind
+	}
 	attribute := keyAttribute
-	if rollout.BucketBy != nil {
-		attribute = *rollout.BucketBy
+	if bucketBy != nil && *bucketBy != "" {
+		attribute = *bucketBy
 	}
-	mapping, isMapped := schema[attribute]
 
-	if isMapped {
-		fmt.Printf("  Adding an instruction to replace %v rollout for user attribute '%v' with %v rollout for '%v' attribute '%v'.\n", rolloutType, attribute, rolloutType, mapping.Kind, mapping.Attribute)
-		instruction := map[string]interface{}{
-			"kind":               interface{}(instructionKind),
-			"rolloutContextKind": interface{}(mapping.Kind),
-			"rolloutBucketBy":    interface{}(mapping.Attribute),
-			"rolloutWeights":     toRolloutWeights(flag, rollout.Variations),
+	if kind == userKind {
+		if mapping, isMapped := mappingForFlag(flag, attribute); isMapped {
+			return mapping.Kind, emittedAttribute(mapping.Attribute), true
 		}
+	}
+	return kind, attribute, false
+}
 
-		if ruleId != nil {
-			instruction["ruleId"] = interface{}(ruleId)
+// Map a rollout of the flag, or of one of its scheduled changes, to the context kind and bucketing attribute it moves
+// to, and report the result
+func migrateRollout(flag ldapi.FeatureFlag, rolloutType string, contextKind *string, bucketBy *string) (string, string, bool) {
+	kind, attribute, moved := rolloutDestination(flag, contextKind, bucketBy)
+	if moved {
+		from := keyAttribute
+		if bucketBy != nil && *bucketBy != "" {
+			from = *bucketBy
 		}
-
-		return &instruction
-	} else {
-		fmt.Printf("  Skipping the fallthrough rollout for user attribute '%v' because no mapping was provided.\n", attribute)
-		return nil
+		fmt.Printf("  Adding an instruction to replace %v rollout for user attribute '%v' with %v rollout for '%v' attribute '%v'.\n", rolloutType, from, rolloutType, kind, attribute)
+	} else if kind == userKind {
+		fmt.Printf("  Skipping %v rollout for user attribute '%v' because no mapping was provided.\n", rolloutType, attribute)
 	}
+	return kind, attribute, moved
 }
 
-// Construct instructions to migrate targeting rule clauses
-func toInstructionClauses(clauses []ldapi.Clause) ([]map[string]interface{}, []string) {
-	toAdd := []map[string]interface{}{}
-	toRemove := []string{}
+// Construct instructions to migrate targeting rule clauses. Each clause is updated in place, so the clause order
+// and the rule's own settings (description, ref and trackEvents) are preserved. When a clause's values map to several
+// context kinds, the rule is updated for the first kind and a copy of the rule is added directly below it for each
+// other combination of kinds, since the copies together match the same contexts as the original rule.
+func toInstructionClauses(flag ldapi.FeatureFlag, rule ldapi.Rule) []map[string]interface{} {
+	instructions := []map[string]interface{}{}
+	alternatives := [][]map[string]interface{}{}
+	hasAlternatives := false
+
+	for _, clause := range rule.Clauses {
+		clauses, mapped := mapClause(flag, clause)
+		alternatives = append(alternatives, clauses)
+		if !mapped {
+			continue
+		}
+		hasAlternatives = hasAlternatives || len(clauses) > 1
+
+		instructions = append(instructions, map[string]interface{}{
+			"kind":     interface{}("updateClause"),
+			"ruleId":   interface{}(*rule.Id),
+			"clauseId": interface{}(*clause.Id),
+			"clause":   clauses[0],
+		})
+		fmt.Printf("  Adding an instruction to update a rule clause in place from user attribute '%v' to a rule clause for '%v' attribute '%v'.\n", clause.Attribute, clauses[0]["contextKind"], clauses[0]["attribute"])
+	}
 
-	for _, clause := range clauses {
-		mapping, isMapped := schema[clause.Attribute]
+	if !hasAlternatives {
+		return instructions
+	}
 
-		if !contains(attributesToIgnore, clause.Attribute) {
-			if isMapped {
-				toAdd = append(toAdd, map[string]interface{}{
-					"attribute":   interface{}(mapping.Attribute),
-					"contextKind": interface{}(mapping.Kind),
-					"negate":      interface{}(clause.Negate),
-					"op":          interface{}(clause.Op),
-					"values":      interface{}(clause.Values),
-				})
-				toRemove = append(toRemove, *clause.Id)
-				fmt.Printf("  Adding instructions to replace a rule clause for user attribute '%v' with a rule clause for '%v' attribute '%v'.\n", clause.Attribute, mapping.Kind, mapping.Attribute)
-			} else {
-				fmt.Printf("  Skipping a targeting rule clause for user attribute '%v' because no mapping was provided.\n", clause.Attribute)
+	// Every combination other than the first one, which the original rule now holds, becomes a copy of the rule
+	for _, combination := range combinations(alternatives)[1:] {
+		kinds := []string{}
+		for _, clause := range combination {
+			kind := clause["contextKind"].(string)
+			if !contains(kinds, kind) {
+				kinds = append(kinds, kind)
 			}
 		}
+
+		instruction := ruleServe(flag, rule)
+		instruction["kind"] = interface{}("addRule")
+		instruction["description"] = interface{}("Migrated from " + ruleLabel(flag, rule) + " for context kind(s) " + strings.Join(kinds, " + "))
+		instruction["clauses"] = combination
+		if nextRuleId := nextRuleId(flag, rule); nextRuleId != nil {
+			instruction["beforeRuleId"] = interface{}(nextRuleId)
+		}
+
+		instructions = append(instructions, instruction)
+		fmt.Printf("  Adding an instruction to add a copy of %v below it that matches on context kind(s): %v.\n", ruleLabel(flag, rule), strings.Join(kinds, " + "))
 	}
 
-	return toAdd, toRemove
+	return instructions
 }
 
-// Helper function to get the flag's variation rollout weights
-func toRolloutWeights(flag ldapi.FeatureFlag, weights []ldapi.WeightedVariation) map[string]int32 {
-	wvs := map[string]int32{}
+// Map a rule clause of the flag, or of one of its scheduled changes, to a clause for each of its destinations, and
+// report what changes. A clause that doesn't refer to the user context kind, or that isn't mapped, is the only
+// alternative to itself, and mapped is false for it.
+func mapClause(flag ldapi.FeatureFlag, clause ldapi.Clause) ([]map[string]interface{}, bool) {
+	if !isUserClause(clause) || contains(attributesToIgnore, clause.Attribute) {
+		return []map[string]interface{}{toMappedClause(flag, clause)}, false
+	}
 
-	for _, wv := range weights {
-		wvs[*flag.Variations[wv.Variation].Id] = wv.Weight
+	destinations := clauseDestinations(flag, clause)
+	if clause.Negate && len(destinations) > 0 && hasMultipleDestinations(flag, clause.Attribute) {
+		fmt.Printf("  Warning: the negated rule clause for user attribute '%v' only moves to '%v' attribute '%v' because negated clauses can't be split across context kinds.\n", clause.Attribute, destinations[0].mapping.Kind, destinations[0].mapping.Attribute)
 	}
-	return wvs
+	if _, _, isBuiltin := legacyBuiltin(clause.Attribute); isBuiltin {
+		reportBuiltinClause(clause.Attribute, len(destinations) > 0)
+	}
+	if len(destinations) == 0 {
+		fmt.Printf("  Skipping a targeting rule clause for user attribute '%v' because no mapping was provided.\n", clause.Attribute)
+		return []map[string]interface{}{toMappedClause(flag, clause)}, false
+	}
+
+	clauses := []map[string]interface{}{}
+	for _, destination := range destinations {
+		migrated := destinationClause(clause, destination)
+		reportTypeProblems(clause.Attribute, clause.Op, migrated["values"].([]interface{}), destination.mapping)
+		clauses = append(clauses, migrated)
+	}
+	return clauses, true
 }
 
-// Helper function to get information about the flag maintainer
-func getMaintainer(flag ldapi.FeatureFlag) (string, string, string) {
-	maintainerTeamKey := ""
-	maintainerMemberId := ""
-	maintainerMemberEmail := ""
+// Helper function to get the migrated form of a clause, using the first destination of a mapped user clause.
+// Clauses without a mapping are returned unchanged.
+func toMappedClause(flag ldapi.FeatureFlag, clause ldapi.Clause) map[string]interface{} {
+	if isUserClause(clause) && !contains(attributesToIgnore, clause.Attribute) {
+		if destinations := clauseDestinations(flag, clause); len(destinations) > 0 {
+			return destinationClause(clause, destinations[0])
+		}
+	}
 
-	if flag.MaintainerTeamKey != nil {
-		maintainerTeamKey = *flag.MaintainerTeamKey
-	} else if flag.MaintainerId != nil {
-		maintainerMemberId = *flag.MaintainerId
-		maintainerMemberEmail = flag.Maintainer.Email
+	contextKind := userKind
+	if clause.ContextKind != nil {
+		contextKind = *clause.ContextKind
 	}
 
-	return maintainerTeamKey, maintainerMemberId, maintainerMemberEmail
+	return map[string]interface{}{
+		"attribute":   interface{}(clause.Attribute),
+		"contextKind": interface{}(contextKind),
+		"negate":      interface{}(clause.Negate),
+		"op":          interface{}(clause.Op),
+		"values":      interface{}(clause.Values),
+	}
+}
+
+// Helper function to get a migrated clause for one of its destinations
+func destinationClause(clause ldapi.Clause, destination clauseDestination) map[string]interface{} {
+	return map[string]interface{}{
+		"attribute":   interface{}(emittedAttribute(destination.mapping.Attribute)),
+		"contextKind": interface{}(destination.mapping.Kind),
+		"negate":      interface{}(clause.Negate),
+		"op":          interface{}(clause.Op),
+		"values":      interface{}(transformValues(clause.Attribute, clause.Op, destination.values, destination.mapping)),
+	}
 }
 
-// Helper function to identify why a flag is/isn't safe to migrate
-func getPreconditionViolations(flag ldapi.FeatureFlag) []string {
-	violations := []string{}
+// Returns true if the clause refers to the user context kind
+func isUserClause(clause ldapi.Clause) bool {
+	return clause.ContextKind == nil || *clause.ContextKind == userKind
+}
+
+// Helper function to get every combination that picks one clause from each list of alternatives, in order
+func combinations(alternatives [][]map[string]interface{}) [][]map[string]interface{} {
+	result := [][]map[string]interface{}{{}}
+
+	for _, clauses := range alternatives {
+		next := [][]map[string]interface{}{}
+		for _, combination := range result {
+			for _, clause := range clauses {
+				extended := append(append([]map[string]interface{}{}, combination...), clause)
+				next = append(next, extended)
+			}
+		}
+		result = next
+	}
+
+	return result
+}
 
-	// Each of these will be true if the corresponding guardrail has been violated.
-	// They need to all be false for it to be safe to migrate a flag.
-	if hasDependentFlags(flag) {
-		violations = append(violations, "  The flag is a prerequisite of dependent flags.")
+// Helper function to get the fields of an addRule instruction that make it serve the same variation or rollout as
+// an existing rule, migrating the rollout's bucketing attribute where it's mapped
+func ruleServe(flag ldapi.FeatureFlag, rule ldapi.Rule) map[string]interface{} {
+	instruction := map[string]interface{}{}
+
+	if rule.Variation != nil {
+		instruction["variationId"] = interface{}(flag.Variations[*rule.Variation].Id)
+	} else if rule.Rollout != nil {
+		rolloutKind, bucketBy, _ := rolloutDestination(flag, rule.Rollout.ContextKind, rule.Rollout.BucketBy)
+		instruction["rolloutContextKind"] = interface{}(rolloutKind)
+		instruction["rolloutBucketBy"] = interface{}(bucketBy)
+		instruction["rolloutWeights"] = toRolloutWeights(flag, rule.Rollout.Variations)
 	}
-	if isReferencedInUnsafeRepo(flag) {
-		violations = append(violations, "  The flag is referenced in one or more unsafe repositories.")
+
+	return instruction
+}
+
+// Helper function to get the ID of the rule that follows a rule, or nil if it's the last rule
+func nextRuleId(flag ldapi.FeatureFlag, rule ldapi.Rule) *string {
+	rules := flag.Environments[envKey].Rules
+	for i, r := range rules {
+		if r.Id != nil && rule.Id != nil && *r.Id == *rule.Id && i+1 < len(rules) {
+			return rules[i+1].Id
+		}
 	}
-	if isReferencedInRunningExperiment(flag) {
-		violations = append(violations, "  The flag is used in a running experiment.")
+	return nil
+}
+
+// Helper function to get the context kinds a rule's clauses will refer to after the migration, and whether the rule
+// is partially mapped, meaning some of its user clauses move to other kinds while others remain on the user kind.
+func ruleKinds(flag ldapi.FeatureFlag, rule ldapi.Rule) ([]string, bool) {
+	kinds := []string{}
+	movedFromUser := false
+	remainsUser := false
+
+	for _, clause := range rule.Clauses {
+		if contains(attributesToIgnore, clause.Attribute) {
+			continue
+		}
+
+		clauseKinds := []string{userKind}
+		if clause.ContextKind != nil {
+			clauseKinds = []string{*clause.ContextKind}
+		}
+		if clauseKinds[0] == userKind {
+			// A clause whose values split across several destinations refers to each of their kinds
+			if destinations := clauseDestinations(flag, clause); len(destinations) > 0 {
+				clauseKinds = []string{}
+				for _, destination := range destinations {
+					clauseKinds = append(clauseKinds, destination.mapping.Kind)
+					movedFromUser = movedFromUser || destination.mapping.Kind != userKind
+				}
+			} else {
+				remainsUser = true
+			}
+		}
+
+		for _, kind := range clauseKinds {
+			if !contains(kinds, kind) {
+				kinds = append(kinds, kind)
+			}
+		}
 	}
 
-	return violations
+	return kinds, movedFromUser && remainsUser
 }
 
-func hasDependentFlags(flag ldapi.FeatureFlag) bool {
-	if len(repos) == 0 {
-		// skip the guardrail check because all flags in this environment are deemed to be safe
-		return false
+// Returns true if any of the flag's rules are partially mapped
+func hasPartiallyMappedRules(flag ldapi.FeatureFlag, details flagDetails) bool {
+	for _, rule := range details.ruleUserRefs {
+		if _, partial := ruleKinds(flag, rule.rule); partial {
+			return true
+		}
 	}
+	return false
+}
 
-	deps, r, err := client.FeatureFlagsBetaApi.GetDependentFlagsByEnv(ctx, projectKey, envKey, flag.Key).Execute()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error when calling `FeatureFlagsBetaApi.GetDependentFlagsByEnv``: %v\n", err)
-		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
+// Report a rule where only some user attributes are mapped, following PARTIAL_RULE_POLICY. Returns true if the rule
+// should be left as it is.
+func skipPartialRule(label string) bool {
+	if partialRulePolicy == partialRuleSkipRule {
+		fmt.Printf("  Skipping %v because only some of its user attributes are mapped.\n", label)
+		return true
 	}
+	fmt.Printf("  Warning: only some of the user attributes in %v are mapped. Its clauses will only match contexts that include all of these kinds.\n", label)
+	return false
+}
 
-	return len(deps.Items) > 0
+// Helper function to get a readable name for a flag rule
+func ruleLabel(flag ldapi.FeatureFlag, rule ldapi.Rule) string {
+	for i, r := range flag.Environments[envKey].Rules {
+		if r.Id != nil && rule.Id != nil && *r.Id == *rule.Id {
+			if rule.Description != nil && *rule.Description != "" {
+				return fmt.Sprintf("rule %v ('%v')", i+1, *rule.Description)
+			}
+			return fmt.Sprintf("rule %v", i+1)
+		}
+	}
+	return "a rule"
 }
 
-func isReferencedInUnsafeRepo(flag ldapi.FeatureFlag) bool {
-	if len(repos) == 0 {
-		// skip the guardrail check because all repos are "ready"
-		return false
+// Construct an instruction that keeps the behavior of a negated clause which moves to a different context kind.
+//
+// Under the user model, a negated clause matched users that didn't have the attribute at all. Under the contexts
+// model, a clause never matches a context that doesn't include the clause's kind, even when it is negated. To keep
+// the original behavior, a copy of the rule is added directly below it where the negated clause is replaced with a
+// clause that matches contexts without that kind.
+func handleNegatedClauses(flag ldapi.FeatureFlag, rule ldapi.Rule) *map[string]interface{} {
+	clauses, description := negatedClauseRewrite(flag, rule.Clauses)
+	if clauses == nil {
+		return nil
 	}
 
-	stats, r, err := client.CodeReferencesApi.GetStatistics(ctx, projectKey).FlagKey(flag.Key).Execute()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Code references is an Enterprise feature. Your LaunchDarkly account must be on an Enterprise plan to use this guardrail.\n")
-		fmt.Fprintf(os.Stderr, "Error when calling `CodeReferencesApi.GetStatistics``: %v\n", err)
-		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
+	instruction := ruleServe(flag, rule)
+	instruction["kind"] = interface{}("addRule")
+	instruction["description"] = interface{}(description)
+	instruction["clauses"] = clauses
+
+	// Place the new rule directly below the rule it was derived from
+	if nextRuleId := nextRuleId(flag, rule); nextRuleId != nil {
+		instruction["beforeRuleId"] = interface{}(nextRuleId)
 	}
+	return &instruction
+}
 
-	flagStats := stats.Flags[flag.Key]
-	for _, stat := range flagStats {
-		if !contains(repos, stat.Name) {
-			return true
+// Helper function to get the clauses of the copy of a rule that keeps the behavior of its negated clause which moves
+// to a different context kind, along with the copy's description. Returns nil if the rule has no such clause, or the
+// copy can't be made.
+func negatedClauseRewrite(flag ldapi.FeatureFlag, clauses []ldapi.Clause) ([]map[string]interface{}, string) {
+	negated := []int{}
+	for i, clause := range clauses {
+		if !clause.Negate || !isUserClause(clause) || contains(attributesToIgnore, clause.Attribute) {
+			continue
+		}
+		if mapping, isMapped := mappingForFlag(flag, clause.Attribute); isMapped && mapping.Kind != userKind {
+			negated = append(negated, i)
+			fmt.Printf("  Warning: a negated rule clause for user attribute '%v' moves to context kind '%v'. Contexts without a '%v' kind matched this clause as users, but won't match it after the migration.\n", clause.Attribute, mapping.Kind, mapping.Kind)
+		}
+	}
+
+	if len(negated) == 0 {
+		return nil, ""
+	} else if len(negated) > 1 {
+		fmt.Printf("  Skipping the rewrite of negated rule clauses because the rule has more than one. Review this rule manually.\n")
+		return nil, ""
+	} else if !rewriteNegatedClauses {
+		fmt.Printf("  Skipping the rewrite of the negated rule clause because NEGATED_CLAUSE_REWRITE isn't provided.\n")
+		return nil, ""
+	}
+
+	negatedClause := clauses[negated[0]]
+	negatedMapping, _ := mappingForFlag(flag, negatedClause.Attribute)
+	negatedKind := negatedMapping.Kind
+	rewritten := []map[string]interface{}{}
+	for i, clause := range clauses {
+		if i == negated[0] {
+			rewritten = append(rewritten, map[string]interface{}{
+				"attribute":   interface{}("kind"),
+				"contextKind": interface{}(negatedKind),
+				"negate":      interface{}(true),
+				"op":          interface{}("in"),
+				"values":      interface{}([]interface{}{negatedKind}),
+			})
+		} else {
+			rewritten = append(rewritten, toMappedClause(flag, clause))
 		}
 	}
 
-	// If we've reached this point, the script argument denotes that at least one repository is "safe".
-	// Let's mark repositories with no code references as "unsafe" because we don't know whether or not they're safe.
-	return len(flagStats) == 0
+	fmt.Printf("  Adding an instruction to add a rule below it for contexts without a '%v' kind, to keep the negated clause's behavior.\n", negatedKind)
+	return rewritten, "Migrated from a negated clause for user attribute '" + negatedClause.Attribute + "'"
 }
 
-func isReferencedInRunningExperiment(flag ldapi.FeatureFlag) bool {
-	exps, r, err := client.ExperimentsBetaApi.GetExperiments(ctx, projectKey, envKey).Filter("flagKey:" + flag.Key + ",status:running").Execute()
+// Helper function to get the flag's variation rollout weights
+func toRolloutWeights(flag ldapi.FeatureFlag, weights []ldapi.WeightedVariation) map[string]int32 {
+	wvs := map[string]int32{}
 
-	if r.StatusCode == 403 {
-		// The customer doesn't pay for Experimentation. Allow the migration to proceed.
-		return false
+	for _, wv := range weights {
+		wvs[*flag.Variations[wv.Variation].Id] = wv.Weight
 	}
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error when calling `ExperimentsBetaApi.GetExperiments``: %v\n", err)
-		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
+	return wvs
+}
+
+// Helper function to get information about the flag maintainer
+func getMaintainer(flag ldapi.FeatureFlag) (string, string, string) {
+	maintainerTeamKey := ""
+	maintainerMemberId := ""
+	maintainerMemberEmail := ""
+
+	if flag.MaintainerTeamKey != nil {
+		maintainerTeamKey = *flag.MaintainerTeamKey
+	} else if flag.MaintainerId != nil {
+		maintainerMemberId = *flag.MaintainerId
+		maintainerMemberEmail = flag.Maintainer.Email
 	}
 
-	// Return true if this flag is used in an actively running experiment
-	return exps != nil && exps.TotalCount != nil && *exps.TotalCount > 0
+	return maintainerTeamKey, maintainerMemberId, maintainerMemberEmail
 }
 
 func contains(s []string, str string) bool {
@@ -0,0 +1,451 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+func TestCombinations(t *testing.T) {
+	a := map[string]interface{}{"attribute": "a"}
+	b1 := map[string]interface{}{"attribute": "b1"}
+	b2 := map[string]interface{}{"attribute": "b2"}
+	c1 := map[string]interface{}{"attribute": "c1"}
+	c2 := map[string]interface{}{"attribute": "c2"}
+
+	tests := []struct {
+		name         string
+		alternatives [][]map[string]interface{}
+		want         [][]map[string]interface{}
+	}{
+		{"no clauses", nil, [][]map[string]interface{}{{}}},
+		{"single choices", [][]map[string]interface{}{{a}, {b1}}, [][]map[string]interface{}{{a, b1}}},
+		{"one split", [][]map[string]interface{}{{a}, {b1, b2}}, [][]map[string]interface{}{{a, b1}, {a, b2}}},
+		{
+			"two splits keep the first choice first",
+			[][]map[string]interface{}{{b1, b2}, {c1, c2}},
+			[][]map[string]interface{}{{b1, c1}, {b1, c2}, {b2, c1}, {b2, c2}},
+		},
+		{"empty alternative", [][]map[string]interface{}{{a}, {}}, [][]map[string]interface{}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := combinations(tt.alternatives); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("combinations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCombinationsDontShareClauses(t *testing.T) {
+	a := map[string]interface{}{"attribute": "a"}
+	b1 := map[string]interface{}{"attribute": "b1"}
+	b2 := map[string]interface{}{"attribute": "b2"}
+
+	result := combinations([][]map[string]interface{}{{a}, {b1, b2}})
+	result[0][0] = b1
+	if result[1][0]["attribute"] != "a" {
+		t.Errorf("changing one combination changed another: %v", result)
+	}
+}
+
+func TestTargetsToRule(t *testing.T) {
+	mapping := attributeSchema{Kind: "account", Attribute: "org/id"}
+	variation := ldapi.Variation{Id: strPtr("variation-on")}
+
+	tests := []struct {
+		name             string
+		rules            []ldapi.Rule
+		wantBeforeRuleId interface{}
+	}{
+		{"without rules", nil, nil},
+		{"ahead of the first rule", []ldapi.Rule{{Id: strPtr("rule-1")}, {Id: strPtr("rule-2")}}, strPtr("rule-1")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flag := ldapi.FeatureFlag{Key: "flag", Environments: map[string]ldapi.FeatureFlagConfig{envKey: {Rules: tt.rules}}}
+			instruction := targetsToRule(flag, variation, []interface{}{"a", "b"}, mapping)
+
+			if instruction["kind"] != "addRule" || instruction["variationId"] != variation.Id {
+				t.Errorf("targetsToRule() = %v, want an addRule instruction that serves %v", instruction, *variation.Id)
+			}
+			if got := instruction["beforeRuleId"]; !reflect.DeepEqual(got, tt.wantBeforeRuleId) {
+				t.Errorf("targetsToRule() beforeRuleId = %v, want %v", got, tt.wantBeforeRuleId)
+			}
+			want := []map[string]interface{}{{
+				"attribute":   "/org~1id",
+				"contextKind": "account",
+				"negate":      false,
+				"op":          "in",
+				"values":      []interface{}{"a", "b"},
+			}}
+			if got := instruction["clauses"]; !reflect.DeepEqual(got, want) {
+				t.Errorf("targetsToRule() clauses = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestKeyClauseRulesToTargets(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{
+		keyAttribute: {Kind: "account", Attribute: keyAttribute},
+	})
+	savedMin := keyClauseTargetsMin
+	t.Cleanup(func() { keyClauseTargetsMin = savedMin })
+	keyClauseTargetsMin = 2
+
+	keyRule := func(id string, variation int32, keys ...interface{}) ldapi.Rule {
+		return ldapi.Rule{
+			Id:        strPtr(id),
+			Variation: &variation,
+			Clauses:   []ldapi.Clause{{Attribute: keyAttribute, ContextKind: strPtr(userKind), Op: "in", Values: keys}},
+		}
+	}
+	tracked := keyRule("rule-3", 0, "e", "f")
+	tracked.TrackEvents = true
+	config := ldapi.FeatureFlagConfig{
+		ContextTargets: []ldapi.Target{{Values: []string{"a"}, Variation: 0, ContextKind: strPtr("account")}},
+		Targets:        []ldapi.Target{{Values: []string{"a", "b"}, Variation: 0}},
+		Rules:          []ldapi.Rule{keyRule("rule-1", 1, "b", "c", "d"), keyRule("rule-2", 0, "c", "x"), tracked},
+	}
+	flag := ldapi.FeatureFlag{
+		Key:          "flag",
+		Variations:   []ldapi.Variation{{Id: strPtr("variation-off")}, {Id: strPtr("variation-on")}},
+		Environments: map[string]ldapi.FeatureFlagConfig{envKey: config},
+	}
+	details := flagDetails{targetUserRefs: []targetInfo{{target: config.Targets[0], variation: flag.Variations[0]}}}
+
+	converted, instructions := keyClauseRulesToTargets(flag, details)
+	if want := map[string]bool{"rule-1": true, "rule-2": true}; !reflect.DeepEqual(converted, want) {
+		t.Errorf("keyClauseRulesToTargets() converted %v, want %v", converted, want)
+	}
+	want := []map[string]interface{}{
+		{"kind": "addTargets", "contextKind": "account", "values": []string{"c", "d"}, "variationId": strPtr("variation-on")},
+		{"kind": "removeRule", "ruleId": strPtr("rule-1")},
+		{"kind": "addTargets", "contextKind": "account", "values": []string{"x"}, "variationId": strPtr("variation-off")},
+		{"kind": "removeRule", "ruleId": strPtr("rule-2")},
+	}
+	if !reflect.DeepEqual(instructions, want) {
+		t.Errorf("keyClauseRulesToTargets() = %v, want %v", instructions, want)
+	}
+}
+
+func TestHandleNegatedClauses(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{
+		"email": {Kind: "account", Attribute: "email"},
+		"plan":  {Kind: "account", Attribute: "plan"},
+	})
+	savedRewrite := rewriteNegatedClauses
+	t.Cleanup(func() { rewriteNegatedClauses = savedRewrite })
+
+	negatedEmail := ldapi.Clause{Attribute: "email", Op: "endsWith", Values: []interface{}{"@example.com"}, Negate: true}
+	negatedPlan := ldapi.Clause{Attribute: "plan", Op: "in", Values: []interface{}{"free"}, Negate: true}
+	country := ldapi.Clause{Attribute: "country", Op: "in", Values: []interface{}{"NZ"}}
+	variation := int32(1)
+	rule := func(id string, clauses ...ldapi.Clause) ldapi.Rule {
+		return ldapi.Rule{Id: strPtr(id), Variation: &variation, Clauses: clauses}
+	}
+
+	tests := []struct {
+		name             string
+		rewrite          bool
+		rules            []ldapi.Rule
+		wantClauses      []map[string]interface{}
+		wantBeforeRuleId interface{}
+	}{
+		{"rewrite not requested", false, []ldapi.Rule{rule("rule-1", country, negatedEmail)}, nil, nil},
+		{"several negated clauses", true, []ldapi.Rule{rule("rule-1", negatedEmail, negatedPlan)}, nil, nil},
+		{
+			"last rule",
+			true,
+			[]ldapi.Rule{rule("rule-1", country, negatedEmail)},
+			[]map[string]interface{}{
+				{"attribute": "country", "contextKind": userKind, "negate": false, "op": "in", "values": []interface{}{"NZ"}},
+				{"attribute": "kind", "contextKind": "account", "negate": true, "op": "in", "values": []interface{}{"account"}},
+			},
+			nil,
+		},
+		{
+			"followed by another rule",
+			true,
+			[]ldapi.Rule{rule("rule-1", negatedEmail, country), rule("rule-2", country)},
+			[]map[string]interface{}{
+				{"attribute": "kind", "contextKind": "account", "negate": true, "op": "in", "values": []interface{}{"account"}},
+				{"attribute": "country", "contextKind": userKind, "negate": false, "op": "in", "values": []interface{}{"NZ"}},
+			},
+			strPtr("rule-2"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewriteNegatedClauses = tt.rewrite
+			flag := ldapi.FeatureFlag{
+				Key:          "flag",
+				Variations:   []ldapi.Variation{{Id: strPtr("variation-off")}, {Id: strPtr("variation-on")}},
+				Environments: map[string]ldapi.FeatureFlagConfig{envKey: {Rules: tt.rules}},
+			}
+			instruction := handleNegatedClauses(flag, tt.rules[0])
+			if tt.wantClauses == nil {
+				if instruction != nil {
+					t.Errorf("handleNegatedClauses() = %v, want nil", *instruction)
+				}
+				return
+			}
+			if instruction == nil {
+				t.Fatal("handleNegatedClauses() = nil, want an instruction")
+			}
+
+			got := *instruction
+			if got["kind"] != "addRule" || !reflect.DeepEqual(got["variationId"], strPtr("variation-on")) {
+				t.Errorf("handleNegatedClauses() = %v, want an addRule instruction that serves variation-on", got)
+			}
+			if !reflect.DeepEqual(got["clauses"], tt.wantClauses) {
+				t.Errorf("handleNegatedClauses() clauses = %v, want %v", got["clauses"], tt.wantClauses)
+			}
+			if !reflect.DeepEqual(got["beforeRuleId"], tt.wantBeforeRuleId) {
+				t.Errorf("handleNegatedClauses() beforeRuleId = %v, want %v", got["beforeRuleId"], tt.wantBeforeRuleId)
+			}
+		})
+	}
+}
+
+func TestRuleKinds(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{
+		"email": {Kind: "account", Attribute: "email"},
+		"name":  {Kind: userKind, Attribute: "name"},
+		"region": {Kind: "location", Attribute: "region", FanOut: []attributeSchema{
+			{Kind: "device", Attribute: "region"},
+		}},
+	})
+
+	email := ldapi.Clause{Attribute: "email", Op: "in", Values: []interface{}{"a@example.com"}}
+	name := ldapi.Clause{Attribute: "name", Op: "in", Values: []interface{}{"Ana"}}
+	region := ldapi.Clause{Attribute: "region", Op: "in", Values: []interface{}{"emea"}}
+	country := ldapi.Clause{Attribute: "country", Op: "in", Values: []interface{}{"NZ"}}
+	device := ldapi.Clause{Attribute: "os", ContextKind: strPtr("device"), Op: "in", Values: []interface{}{"ios"}}
+
+	tests := []struct {
+		name        string
+		clauses     []ldapi.Clause
+		wantKinds   []string
+		wantPartial bool
+	}{
+		{"mapped clause", []ldapi.Clause{email}, []string{"account"}, false},
+		{"unmapped clause", []ldapi.Clause{country}, []string{userKind}, false},
+		{"mapped to the user kind", []ldapi.Clause{name, country}, []string{userKind}, false},
+		{"partially mapped", []ldapi.Clause{email, country}, []string{"account", userKind}, true},
+		{"fanned-out clause", []ldapi.Clause{region}, []string{"location", "device"}, false},
+		{"other context kind", []ldapi.Clause{email, device}, []string{"account", "device"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kinds, partial := ruleKinds(ldapi.FeatureFlag{Key: "flag"}, ldapi.Rule{Clauses: tt.clauses})
+			if !reflect.DeepEqual(kinds, tt.wantKinds) || partial != tt.wantPartial {
+				t.Errorf("ruleKinds() = %v, %v, want %v, %v", kinds, partial, tt.wantKinds, tt.wantPartial)
+			}
+		})
+	}
+}
+
+func TestSkipPartialRule(t *testing.T) {
+	savedPolicy := partialRulePolicy
+	t.Cleanup(func() { partialRulePolicy = savedPolicy })
+
+	tests := []struct {
+		policy string
+		want   bool
+	}{
+		{partialRuleWarn, false},
+		{partialRuleSkipRule, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			partialRulePolicy = tt.policy
+			if got := skipPartialRule("rule 1"); got != tt.want {
+				t.Errorf("skipPartialRule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPartialRules(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{
+		"email": {Kind: "account", Attribute: "email"},
+	})
+	savedPolicy := partialRulePolicy
+	t.Cleanup(func() { partialRulePolicy = savedPolicy })
+
+	clauses := []ldapi.Clause{
+		{Attribute: "email", ContextKind: strPtr(userKind), Op: "in", Values: []interface{}{"a@example.com"}},
+		{Attribute: "country", ContextKind: strPtr(userKind), Op: "in", Values: []interface{}{"NZ"}},
+	}
+	variation := int32(0)
+	flag := ldapi.FeatureFlag{
+		Key:          "flag",
+		Variations:   []ldapi.Variation{{}},
+		Environments: map[string]ldapi.FeatureFlagConfig{envKey: {Rules: []ldapi.Rule{{Id: strPtr("rule-1"), Variation: &variation, Clauses: clauses}}}},
+	}
+
+	tests := []struct {
+		policy     string
+		violations int
+	}{
+		{partialRuleWarn, 0},
+		{partialRuleSkipRule, 0},
+		{partialRuleSkipFlag, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			partialRulePolicy = tt.policy
+			if violations, err := checkPartialRules(ctx, flag); err != nil || len(violations) != tt.violations {
+				t.Errorf("checkPartialRules() = %v, %v, want %v violation(s)", violations, err, tt.violations)
+			}
+		})
+	}
+}
+
+func TestSplitValues(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{
+		"orgId": {Kind: "organization", Attribute: "key", Conditions: []conditionalMapping{
+			{ValuePattern: "^ws-", Kind: "workspace", Attribute: "key"},
+			{ValuePattern: "^team-", Kind: "team", Attribute: "key"},
+		}},
+		"region": {Kind: "location", Attribute: "region", FanOut: []attributeSchema{
+			{Kind: "device", Attribute: "region"},
+		}},
+	})
+	for i := range schema["orgId"].Conditions {
+		if err := validateCondition(&schema["orgId"].Conditions[i]); err != nil {
+			t.Fatalf("validateCondition() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		attribute string
+		values    []interface{}
+		want      map[string][]interface{}
+	}{
+		{"unmapped attribute", "email", []interface{}{"a"}, map[string][]interface{}{}},
+		{"no values", "orgId", nil, map[string][]interface{}{"organization": nil}},
+		{"values that match no condition", "orgId", []interface{}{"acme", "initech"}, map[string][]interface{}{"organization": {"acme", "initech"}}},
+		{"values that match one condition", "orgId", []interface{}{"ws-1", "ws-2"}, map[string][]interface{}{"workspace": {"ws-1", "ws-2"}}},
+		{
+			"values split across conditions",
+			"orgId",
+			[]interface{}{"acme", "ws-1", "team-1", "ws-2"},
+			map[string][]interface{}{"organization": {"acme"}, "workspace": {"ws-1", "ws-2"}, "team": {"team-1"}},
+		},
+		{"fan-out", "region", []interface{}{"emea"}, map[string][]interface{}{"location": {"emea"}, "device": {"emea"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string][]interface{}{}
+			for _, destination := range splitValues(ldapi.FeatureFlag{Key: "flag"}, tt.attribute, tt.values) {
+				got[destination.mapping.Kind] = destination.values
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitValues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToInstructionClausesFanOut(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{
+		"region": {Kind: "location", Attribute: "region", FanOut: []attributeSchema{{Kind: "device", Attribute: "region"}}},
+		"plan":   {Kind: "account", Attribute: "plan", FanOut: []attributeSchema{{Kind: "organization", Attribute: "plan"}}},
+	})
+
+	variation := int32(0)
+	rule := ldapi.Rule{
+		Id:        strPtr("rule-1"),
+		Variation: &variation,
+		Clauses: []ldapi.Clause{
+			{Id: strPtr("clause-1"), Attribute: "region", ContextKind: strPtr(userKind), Op: "in", Values: []interface{}{"emea"}},
+			{Id: strPtr("clause-2"), Attribute: "plan", ContextKind: strPtr(userKind), Op: "in", Values: []interface{}{"pro"}},
+		},
+	}
+	flag := ldapi.FeatureFlag{
+		Key:          "flag",
+		Variations:   []ldapi.Variation{{Id: strPtr("variation-on")}},
+		Environments: map[string]ldapi.FeatureFlagConfig{envKey: {Rules: []ldapi.Rule{rule, {Id: strPtr("rule-2")}}}},
+	}
+
+	instructions := toInstructionClauses(flag, rule)
+	kinds := []string{}
+	for _, instruction := range instructions {
+		switch instruction["kind"] {
+		case "updateClause":
+			kinds = append(kinds, instruction["clause"].(map[string]interface{})["contextKind"].(string))
+		case "addRule":
+			if !reflect.DeepEqual(instruction["beforeRuleId"], strPtr("rule-2")) || !reflect.DeepEqual(instruction["variationId"], strPtr("variation-on")) {
+				t.Errorf("toInstructionClauses() added %v, want a copy below rule-1 that serves variation-on", instruction)
+			}
+			combination := []string{}
+			for _, clause := range instruction["clauses"].([]map[string]interface{}) {
+				combination = append(combination, clause["contextKind"].(string))
+			}
+			kinds = append(kinds, combination...)
+		}
+	}
+
+	// The original rule holds the first combination, and each other combination becomes a copy of it
+	want := []string{"location", "account", "location", "organization", "device", "account", "device", "organization"}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("toInstructionClauses() context kinds = %v, want %v", kinds, want)
+	}
+}
+
+func TestFlagDestinations(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{
+		keyAttribute: {Kind: "account", Attribute: keyAttribute},
+		"orgId": {Kind: "organization", Attribute: keyAttribute, Conditions: []conditionalMapping{
+			{ValuePattern: "^ws-", Kind: "workspace", Attribute: keyAttribute},
+		}},
+	})
+	if err := validateCondition(&schema["orgId"].Conditions[0]); err != nil {
+		t.Fatalf("validateCondition() error = %v", err)
+	}
+
+	orgId := "orgId"
+	tests := []struct {
+		name    string
+		details flagDetails
+		want    []string
+	}{
+		{
+			"clause split across kinds",
+			flagDetails{ruleUserRefs: []ruleInfo{{clauses: []ldapi.Clause{{Attribute: "orgId", Op: "in", Values: []interface{}{"acme", "ws-1"}}}}}},
+			[]string{"organization", "workspace"},
+		},
+		{
+			"negated clause",
+			flagDetails{ruleUserRefs: []ruleInfo{{clauses: []ldapi.Clause{{Attribute: "orgId", Op: "in", Values: []interface{}{"acme", "ws-1"}, Negate: true}}}}},
+			[]string{"organization"},
+		},
+		{"user rollout", flagDetails{fallthroughRollout: &ldapi.Rollout{ContextKind: strPtr(userKind), BucketBy: &orgId}}, []string{"organization"}},
+		{"rollout without a kind", flagDetails{fallthroughRollout: &ldapi.Rollout{}}, []string{"account"}},
+		{"rollout on another kind", flagDetails{ruleUserRefs: []ruleInfo{{rollout: &ldapi.Rollout{ContextKind: strPtr("device")}}}}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kinds := []string{}
+			for _, destination := range flagDestinations(ldapi.FeatureFlag{Key: "flag"}, tt.details) {
+				kinds = append(kinds, destination.Kind)
+			}
+			if !reflect.DeepEqual(kinds, tt.want) {
+				t.Errorf("flagDestinations() kinds = %v, want %v", kinds, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+// Approval request statuses that haven't been applied yet
+var pendingApprovalStatuses = []string{"pending", "scheduled"}
+
+// Workflow stage statuses that have already run
+var finishedStageStatuses = []string{"completed", "failed"}
+
+// Instructions that always refer to the user context kind
+var userTargetInstructions = []string{"addUserTargets", "removeUserTargets", "replaceUserTargets"}
+
+// A workflow stage that hasn't run yet
+type workflowStage struct {
+	workflow ldapi.CustomWorkflowOutput
+	stage    ldapi.StageOutput
+}
+
+// Returns true if any of the semantic patch instructions adds, removes or changes user targeting. Targets, clauses and
+// rollouts without a context kind refer to the user context kind.
+func instructionsReferenceUsers(instructions []map[string]interface{}) bool {
+	for _, instruction := range instructions {
+		if kind, _ := instruction["kind"].(string); contains(userTargetInstructions, kind) {
+			return true
+		}
+		if referencesUsers(instruction) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to search an instruction, and the targets, rules and clauses nested in it, for the user context kind
+func referencesUsers(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, field := range []string{"contextKind", "rolloutContextKind"} {
+			if kind, isString := v[field].(string); isString && kind == userKind {
+				return true
+			}
+		}
+		_, hasContextKind := v["contextKind"]
+		_, hasAttribute := v["attribute"]
+		_, hasOp := v["op"]
+		if hasAttribute && hasOp && !hasContextKind {
+			return true
+		}
+		_, hasWeights := v["rolloutWeights"]
+		_, hasRolloutContextKind := v["rolloutContextKind"]
+		if hasWeights && !hasRolloutContextKind {
+			return true
+		}
+		for _, nested := range v {
+			if referencesUsers(nested) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, nested := range v {
+			if referencesUsers(nested) {
+				return true
+			}
+		}
+	case []map[string]interface{}:
+		for _, nested := range v {
+			if referencesUsers(nested) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Helper function to format a LaunchDarkly timestamp for the report
+func formatTimestamp(millis int64) string {
+	return time.UnixMilli(millis).UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// Helper function to get a readable name for a scheduled change
+func scheduledChangeLabel(change ldapi.FeatureFlagScheduledChange) string {
+	return fmt.Sprintf("the scheduled change for %v (ID %v)", formatTimestamp(change.ExecutionDate), change.Id)
+}
+
+// Helper function to get a readable name for a workflow
+func workflowLabel(workflow ldapi.CustomWorkflowOutput) string {
+	return fmt.Sprintf("workflow '%v' (ID %v)", workflow.Name, workflow.Id)
+}
+
+// Helper function to get a readable name for a workflow stage
+func workflowStageLabel(pending workflowStage) string {
+	stageName := pending.stage.Id
+	if pending.stage.Name != nil && *pending.stage.Name != "" {
+		stageName = *pending.stage.Name
+	}
+	return fmt.Sprintf("stage '%v' of %v", stageName, workflowLabel(pending.workflow))
+}
+
+// Get the flag's scheduled changes in the environment that change user targeting
+func pendingScheduledChanges(ctx context.Context, flag ldapi.FeatureFlag) ([]ldapi.FeatureFlagScheduledChange, error) {
+	changes, r, err := client.ScheduledChangesApi.GetFlagConfigScheduledChanges(ctx, projectKey, flag.Key, envKey).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("error when calling `ScheduledChangesApi.GetFlagConfigScheduledChanges`: %v (full HTTP response: %v)", err, r)
+	}
+
+	pending := []ldapi.FeatureFlagScheduledChange{}
+	for _, change := range changes.Items {
+		if instructionsReferenceUsers(change.Instructions) {
+			pending = append(pending, change)
+		}
+	}
+	return pending, nil
+}
+
+// Returned when the account or API key has no access to workflows, so that no flag can have any
+var errWorkflowsUnavailable = errors.New("workflows aren't available")
+
+// Get the stages of the flag's active workflows in the environment that haven't run yet and change user targeting
+func pendingWorkflowStages(ctx context.Context, flag ldapi.FeatureFlag) ([]workflowStage, error) {
+	workflows, r, err := client.WorkflowsBetaApi.GetWorkflows(ctx, projectKey, flag.Key, envKey).Status("active").Execute()
+	if r != nil && (r.StatusCode == 403 || r.StatusCode == 404) {
+		return nil, errWorkflowsUnavailable
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error when calling `WorkflowsBetaApi.GetWorkflows`: %v (full HTTP response: %v)", err, r)
+	}
+
+	pending := []workflowStage{}
+	for _, workflow := range workflows.Items {
+		for _, stage := range workflow.Stages {
+			if !contains(finishedStageStatuses, stage.Execution.Status) && instructionsReferenceUsers(stage.Action.Instructions) {
+				pending = append(pending, workflowStage{workflow, stage})
+			}
+		}
+	}
+	return pending, nil
+}
+
+// Get a message for each of the flag's unapplied approval requests in the environment that change user targeting
+func pendingApprovals(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error) {
+	approvals, r, err := client.ApprovalsApi.GetApprovalsForFlag(ctx, projectKey, flag.Key, envKey).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("error when calling `ApprovalsApi.GetApprovalsForFlag`: %v (full HTTP response: %v)", err, r)
+	}
+
+	pending := []string{}
+	for _, approval := range approvals.Items {
+		if !contains(pendingApprovalStatuses, approval.Status) || !instructionsReferenceUsers(approval.Instructions) {
+			continue
+		}
+		description := ""
+		if approval.Description != nil && *approval.Description != "" {
+			description = fmt.Sprintf(" '%v'", *approval.Description)
+		}
+		if approval.ExecutionDate != nil {
+			pending = append(pending, fmt.Sprintf("The flag has an approval request%v (ID %v) scheduled for %v that changes user targeting.", description, approval.Id, formatTimestamp(*approval.ExecutionDate)))
+		} else {
+			pending = append(pending, fmt.Sprintf("The flag has a %v approval request%v (ID %v) that changes user targeting.", approval.ReviewStatus, description, approval.Id))
+		}
+	}
+	return pending, nil
+}
+
+// Find the flag's scheduled changes, workflow stages that haven't run yet, and unapplied approval requests in the
+// environment that change user targeting. A migration approval would conflict with them, or they would add user
+// targeting back after the migration. Accounts without workflows have no workflow stages.
+func pendingUserChanges(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error) {
+	pending := []string{}
+
+	changes, err := pendingScheduledChanges(ctx, flag)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		pending = append(pending, fmt.Sprintf("The flag has %v that changes user targeting.", scheduledChangeLabel(change)))
+	}
+
+	stages, err := pendingWorkflowStages(ctx, flag)
+	if err != nil && !errors.Is(err, errWorkflowsUnavailable) {
+		return nil, err
+	}
+	for _, stage := range stages {
+		pending = append(pending, fmt.Sprintf("The flag's %v changes user targeting.", workflowStageLabel(stage)))
+	}
+
+	approvals, err := pendingApprovals(ctx, flag)
+	if err != nil {
+		return nil, err
+	}
+	return append(pending, approvals...), nil
+}
+
+func checkPendingChanges(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error) {
+	if !blockPendingChanges {
+		return nil, nil
+	}
+	return pendingUserChanges(ctx, flag)
+}
+
+// Get a warning for each of the flag's pending approval requests that change user targeting, when they don't block
+// the migration. Scheduled changes and workflows are migrated along with the flag.
+func pendingChangeWarnings(ctx context.Context, flag ldapi.FeatureFlag) []string {
+	if blockPendingChanges {
+		return nil
+	}
+
+	pending, err := pendingApprovals(ctx, flag)
+	if err != nil {
+		return []string{fmt.Sprintf("  Warning: the flag's approval requests couldn't be checked: %v.", err)}
+	}
+	warnings := []string{}
+	for _, item := range pending {
+		warnings = append(warnings, fmt.Sprintf("  Warning: %v The migration may conflict with it or be undone by it.", item))
+	}
+	return warnings
+}
@@ -0,0 +1,32 @@
+package migrator
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPendingChangeWarnings(t *testing.T) {
+	savedBlock := blockPendingChanges
+	t.Cleanup(func() { blockPendingChanges = savedBlock })
+	useAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"items": [
+			{"_id": "a1", "status": "pending", "reviewStatus": "pending", "instructions": [{"kind": "addUserTargets", "values": ["u"]}]},
+			{"_id": "a2", "status": "completed", "reviewStatus": "approved", "instructions": [{"kind": "addUserTargets", "values": ["u"]}]},
+			{"_id": "a3", "status": "pending", "reviewStatus": "pending", "instructions": [{"kind": "turnFlagOn"}]}
+		]}`))
+	})
+
+	blockPendingChanges = false
+	warnings := pendingChangeWarnings(ctx, prerequisiteFlag("flag"))
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "(ID a1)") {
+		t.Errorf("pendingChangeWarnings() = %v, want a warning for approval a1", warnings)
+	}
+
+	// The pending-changes guardrail reports them instead
+	blockPendingChanges = true
+	if warnings := pendingChangeWarnings(ctx, prerequisiteFlag("flag")); len(warnings) != 0 {
+		t.Errorf("pendingChangeWarnings() with BLOCK_PENDING_CHANGES = %v, want none", warnings)
+	}
+}
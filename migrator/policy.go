@@ -0,0 +1,276 @@
+package migrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+	"gopkg.in/yaml.v3"
+)
+
+// A policy rule blocks the migration of every flag that matches all of its criteria. The `when` expression can
+// combine conditions on flag fields with `and`, `or` and `not`; the other criteria are shorthands that must also match.
+type policyRule struct {
+	Name          string
+	When          string
+	FlagTags      []string `yaml:"flagTags"`
+	FlagKeys      []string `yaml:"flagKeys"`
+	Temporary     *bool
+	CreatedBefore string `yaml:"createdBefore"`
+	CreatedAfter  string `yaml:"createdAfter"`
+	Maintainers   []string
+	createdBefore time.Time
+	createdAfter  time.Time
+	when          policyExpr
+}
+
+var policyRules []policyRule
+
+// How long GUARDRAIL_COMMAND may run for each flag
+const guardrailCommandTimeout = 30 * time.Second
+
+// Dates in the policy file may be written as days or as full timestamps
+var policyDateLayouts = []string{"2006-01-02", time.RFC3339}
+
+func preparePolicy() {
+	if policyFile == "" {
+		return
+	}
+
+	file, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		log.Fatal(err)
+		os.Exit(12)
+	}
+
+	var document struct {
+		Rules []policyRule
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(file))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&document); err != nil && !errors.Is(err, io.EOF) {
+		log.Fatal(err)
+		os.Exit(12)
+	}
+
+	policyErrors := []string{}
+	for i := range document.Rules {
+		if err := validatePolicyRule(&document.Rules[i]); err != nil {
+			policyErrors = append(policyErrors, fmt.Sprintf("Policy rule %v %v.", i+1, err))
+		}
+	}
+	if len(policyErrors) > 0 {
+		for _, policyError := range policyErrors {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", policyError)
+		}
+		log.Fatalf("The policy file '%v' has %v error(s).", policyFile, len(policyErrors))
+		os.Exit(12)
+	}
+	policyRules = document.Rules
+
+	fmt.Printf("Using %v policy rule(s) from the policy file.\n", len(policyRules))
+	fmt.Println()
+}
+
+// Check a policy rule's criteria. Dates are parsed here so that they're ready when the rule is applied.
+func validatePolicyRule(rule *policyRule) error {
+	if rule.When == "" && len(rule.FlagTags) == 0 && len(rule.FlagKeys) == 0 && rule.Temporary == nil && rule.CreatedBefore == "" && rule.CreatedAfter == "" && len(rule.Maintainers) == 0 {
+		return fmt.Errorf("needs at least one of when, flagTags, flagKeys, temporary, createdBefore, createdAfter or maintainers")
+	}
+
+	if rule.When != "" {
+		expr, err := parsePolicyExpr(rule.When)
+		if err != nil {
+			return fmt.Errorf("has an invalid when expression: %v", err)
+		}
+		rule.when = expr
+	}
+
+	for _, pattern := range rule.FlagKeys {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("has an invalid flag key pattern '%v'", pattern)
+		}
+	}
+
+	var err error
+	if rule.CreatedBefore != "" {
+		if rule.createdBefore, err = parsePolicyDate(rule.CreatedBefore); err != nil {
+			return fmt.Errorf("has an invalid createdBefore date '%v'", rule.CreatedBefore)
+		}
+	}
+	if rule.CreatedAfter != "" {
+		if rule.createdAfter, err = parsePolicyDate(rule.CreatedAfter); err != nil {
+			return fmt.Errorf("has an invalid createdAfter date '%v'", rule.CreatedAfter)
+		}
+	}
+
+	return nil
+}
+
+func parsePolicyDate(date string) (time.Time, error) {
+	var err error
+	for _, layout := range policyDateLayouts {
+		var parsed time.Time
+		if parsed, err = time.Parse(layout, date); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// Returns true if the flag matches every criterion of the rule. Tags and key patterns match the same way as they do
+// for conditional mappings. Maintainers match by member email, member ID or team key.
+func (r policyRule) matchesFlag(flag ldapi.FeatureFlag) bool {
+	if r.when != nil && !r.when.eval(flag) {
+		return false
+	}
+
+	if !(conditionalMapping{FlagTags: r.FlagTags, FlagKeys: r.FlagKeys}).matchesFlag(flag) {
+		return false
+	}
+
+	if r.Temporary != nil && *r.Temporary != flag.Temporary {
+		return false
+	}
+
+	created := time.UnixMilli(flag.CreationDate)
+	if !r.createdBefore.IsZero() && !created.Before(r.createdBefore) {
+		return false
+	}
+	if !r.createdAfter.IsZero() && !created.After(r.createdAfter) {
+		return false
+	}
+
+	if len(r.Maintainers) > 0 {
+		teamKey, memberId, memberEmail := getMaintainer(flag)
+		maintained := false
+		for _, maintainer := range []string{teamKey, memberId, memberEmail} {
+			maintained = maintained || (maintainer != "" && contains(r.Maintainers, maintainer))
+		}
+		if !maintained {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Helper function to get a readable name for a policy rule
+func (r policyRule) label(index int) string {
+	if r.Name != "" {
+		return fmt.Sprintf("'%v'", r.Name)
+	}
+	return fmt.Sprintf("%v", index+1)
+}
+
+func checkPolicy(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error) {
+	violations := []string{}
+	for i, rule := range policyRules {
+		if rule.matchesFlag(flag) {
+			violations = append(violations, fmt.Sprintf("The flag matches policy rule %v.", rule.label(i)))
+		}
+	}
+	return violations, nil
+}
+
+// Split GUARDRAIL_COMMAND into the executable and its arguments the way a shell does, so that quoted arguments and
+// paths with spaces stay whole. Nothing is expanded. Single quotes keep everything up to the closing quote, double
+// quotes do too except that a backslash escapes '"', '\', '$' and '`', and a backslash outside quotes keeps the
+// character after it.
+func splitCommandLine(line string) ([]string, error) {
+	words := []string{}
+	word := strings.Builder{}
+	inWord := false
+	quote := rune(0)
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else if r == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`", runes[i+1]) {
+				i++
+				word.WriteRune(runes[i])
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("it ends with an unfinished escape")
+			}
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("it has an unclosed %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// Run GUARDRAIL_COMMAND for the flag. The command receives the flag as JSON on stdin and must print a JSON array of
+// violation messages on stdout, which is empty when the flag is safe to migrate. A command that exits with an error
+// or prints anything else leaves the flag unchecked.
+func checkExternalCommand(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error) {
+	if len(guardrailCommand) == 0 {
+		return nil, nil
+	}
+
+	input, err := json.Marshal(flag)
+	if err != nil {
+		return nil, err
+	}
+
+	commandCtx, cancel := context.WithTimeout(ctx, guardrailCommandTimeout)
+	defer cancel()
+	command := exec.CommandContext(commandCtx, guardrailCommand[0], guardrailCommand[1:]...)
+	command.Stdin = bytes.NewReader(input)
+	command.Stderr = os.Stderr
+	output, err := command.Output()
+	if err != nil {
+		return nil, fmt.Errorf("the command '%v' failed: %v", strings.Join(guardrailCommand, " "), err)
+	}
+
+	var violations []string
+	if err := json.Unmarshal(output, &violations); err != nil {
+		return nil, fmt.Errorf("the command '%v' didn't print a JSON array of strings: %v", strings.Join(guardrailCommand, " "), err)
+	}
+	return violations, nil
+}
@@ -0,0 +1,37 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{"./check.sh", []string{"./check.sh"}, false},
+		{"  python3   check.py --strict ", []string{"python3", "check.py", "--strict"}, false},
+		{`"/opt/My Tools/check" --team 'payments team'`, []string{"/opt/My Tools/check", "--team", "payments team"}, false},
+		{`/opt/My\ Tools/check`, []string{"/opt/My Tools/check"}, false},
+		{`check --name="a \"b\" c" --path 'C:\tmp'`, []string{"check", `--name=a "b" c`, "--path", `C:\tmp`}, false},
+		{`check "" --last`, []string{"check", "", "--last"}, false},
+		{`check $HOME *.json`, []string{"check", "$HOME", "*.json"}, false},
+		{`check "unclosed`, nil, true},
+		{`check 'unclosed`, nil, true},
+		{`check \`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := splitCommandLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitCommandLine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitCommandLine() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
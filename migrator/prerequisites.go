@@ -0,0 +1,389 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+const clusterGuardrailName = "prerequisite-cluster"
+
+// The prerequisite relationships between the flags of an environment
+type prerequisiteGraph struct {
+	flags         map[string]ldapi.FeatureFlag
+	prerequisites map[string][]string
+	dependents    map[string][]string
+}
+
+func buildPrerequisiteGraph(flags []ldapi.FeatureFlag, env string) prerequisiteGraph {
+	graph := prerequisiteGraph{
+		flags:         map[string]ldapi.FeatureFlag{},
+		prerequisites: map[string][]string{},
+		dependents:    map[string][]string{},
+	}
+
+	for _, flag := range flags {
+		graph.flags[flag.Key] = flag
+		for _, prerequisite := range flag.Environments[env].Prerequisites {
+			if !contains(graph.prerequisites[flag.Key], prerequisite.Key) {
+				graph.prerequisites[flag.Key] = append(graph.prerequisites[flag.Key], prerequisite.Key)
+				graph.dependents[prerequisite.Key] = append(graph.dependents[prerequisite.Key], flag.Key)
+			}
+		}
+	}
+
+	return graph
+}
+
+// Helper function to get the flags connected to a flag through prerequisites in either direction, including the flag
+// itself. A prerequisite is evaluated as part of its dependents, so the flags of a cluster have to move to the same
+// context kinds together.
+func (g prerequisiteGraph) cluster(flagKey string) []string {
+	cluster := []string{flagKey}
+	seen := map[string]bool{flagKey: true}
+
+	for i := 0; i < len(cluster); i++ {
+		for _, next := range append(append([]string{}, g.prerequisites[cluster[i]]...), g.dependents[cluster[i]]...) {
+			if !seen[next] {
+				seen[next] = true
+				cluster = append(cluster, next)
+			}
+		}
+	}
+
+	sort.Strings(cluster)
+	return cluster
+}
+
+// The prerequisite graph of the environment and the flags selected for this migration, which the prerequisite cluster
+// guardrail checks flags against. Migrate sets it once the other guardrails have checked every selected flag.
+var clusters clusterState
+
+type clusterState struct {
+	graph    prerequisiteGraph
+	selected map[string]bool
+	unsafe   map[string]bool // The selected flags that the other guardrails keep from being migrated
+}
+
+// Record the results of the other guardrails for the prerequisite cluster guardrail, and link each selected flag that
+// targets users to the other flags of its cluster
+func prepareClusters(graph prerequisiteGraph, allDetails map[string]flagDetails) {
+	clusters = clusterState{graph, map[string]bool{}, map[string]bool{}}
+	for flagKey, details := range allDetails {
+		clusters.selected[flagKey] = true
+		if len(details.guardrailMessages) > 0 {
+			clusters.unsafe[flagKey] = true
+		}
+	}
+
+	for flagKey, details := range allDetails {
+		if cluster := graph.cluster(flagKey); len(cluster) > 1 && isFlagTargetingUsers(details) {
+			details.cluster = without(cluster, flagKey)
+			allDetails[flagKey] = details
+		}
+	}
+}
+
+// Fail unless every other flag of the flag's prerequisite cluster that targets users is safe and selected for this
+// migration. Unlike the unsafe-repositories guardrail, this runs whether or not REPOSITORIES is provided, since a flag
+// that moves to other context kinds without its prerequisites or dependents breaks their evaluation either way.
+func checkPrerequisiteCluster(ctx context.Context, flag ldapi.FeatureFlag) ([]string, error) {
+	unsafe := []string{}
+	unselected := []string{}
+	for _, member := range without(clusters.graph.cluster(flag.Key), flag.Key) {
+		if clusters.selected[member] {
+			if clusters.unsafe[member] {
+				unsafe = append(unsafe, member)
+			}
+		} else if memberFlag, exists := clusters.graph.flags[member]; !exists || isFlagTargetingUsers(userReferences(memberFlag)) {
+			unselected = append(unselected, member)
+		}
+	}
+
+	violations := []string{}
+	if len(unsafe) > 0 {
+		violations = append(violations, fmt.Sprintf("The flag's prerequisite cluster includes flags that aren't safe to migrate: %v.", strings.Join(unsafe, ", ")))
+	}
+	if len(unselected) > 0 {
+		violations = append(violations, fmt.Sprintf("The flag's prerequisite cluster includes flags that target users but aren't selected by LD_FLAGS: %v.", strings.Join(unselected, ", ")))
+	}
+	return violations, nil
+}
+
+// A migration approval for a flag in a prerequisite cluster. The approvals of a cluster are submitted together, once
+// every flag of the cluster has been prepared, so that each can link to the others.
+type clusterApproval struct {
+	flag         ldapi.FeatureFlag
+	details      flagDetails
+	description  string
+	instructions []map[string]interface{}
+}
+
+var queuedClusterApprovals []clusterApproval
+
+// Helper function to check whether a flag belongs to the cluster whose approvals are queued
+func inQueuedCluster(flagKey string) bool {
+	if len(queuedClusterApprovals) == 0 {
+		return false
+	}
+	first := queuedClusterApprovals[0]
+	return first.flag.Key == flagKey || contains(first.details.cluster, flagKey)
+}
+
+// Submit the queued approvals of a prerequisite cluster, and comment on each with the IDs and links of the others.
+// Returns true if only some of the approvals could be submitted.
+func submitClusterApprovals() bool {
+	queued := queuedClusterApprovals
+	queuedClusterApprovals = nil
+
+	ids := map[string]string{}
+	submitted := []string{}
+	failed := []string{}
+	for _, approval := range queued {
+		if id, ok := submitApproval(approval.flag, approval.details, approval.description, approval.instructions, nil); ok {
+			ids[approval.flag.Key] = id
+			submitted = append(submitted, fmt.Sprintf("%v (%v)", approval.flag.Key, id))
+		} else {
+			failed = append(failed, approval.flag.Key)
+		}
+	}
+
+	for _, approval := range queued {
+		id, ok := ids[approval.flag.Key]
+		if !ok {
+			continue
+		}
+		links := []string{}
+		for _, other := range queued {
+			if otherId, ok := ids[other.flag.Key]; ok && other.flag.Key != approval.flag.Key {
+				links = append(links, fmt.Sprintf("%v (approval %v: %v)", other.flag.Key, otherId, approvalURL(other.flag.Key, otherId)))
+			}
+		}
+		comment := ""
+		if len(links) > 0 {
+			comment = "Apply this together with the migration approvals for the flags it shares prerequisites with: " + strings.Join(links, ", ") + "."
+		}
+		if len(failed) > 0 {
+			comment += fmt.Sprintf(" The migration approvals for %v couldn't be submitted, so don't apply this one until they are.", strings.Join(failed, ", "))
+		}
+		if comment != "" {
+			commentOnApproval(approval.flag.Key, id, strings.TrimSpace(comment))
+		}
+	}
+
+	if len(submitted) == 0 || len(failed) == 0 {
+		return false
+	}
+	fmt.Printf("Warning: the prerequisite cluster of %v was only partially submitted. Approvals were submitted for %v, but not for %v. Don't apply the submitted approvals until the others are submitted too, for example by running the script again with LD_FLAGS=%v.\n",
+		strings.Join(clusters.graph.cluster(queued[0].flag.Key), ", "), strings.Join(submitted, ", "), strings.Join(failed, ", "), strings.Join(failed, ","))
+	return true
+}
+
+// Helper function to get the link to a flag's approval request in the environment
+func approvalURL(flagKey string, approvalId string) string {
+	return fmt.Sprintf("%v/%v/%v/features/%v/approvals/%v", host, projectKey, envKey, flagKey, approvalId)
+}
+
+// Add a comment to a flag's approval request
+func commentOnApproval(flagKey string, approvalId string, comment string) {
+	req := ldapi.PostApprovalRequestReviewRequest{Kind: ldapi.PtrString("comment"), Comment: &comment}
+	_, r, err := client.ApprovalsApi.PostApprovalRequestReviewForFlag(ctx, projectKey, flagKey, envKey, approvalId).PostApprovalRequestReviewRequest(req).Execute()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when calling `ApprovalsApi.PostApprovalRequestReviewForFlag`: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
+	}
+}
+
+// Helper function to get the order to inspect and migrate flags in, where the selected flags of each prerequisite
+// cluster follow each other
+func migrationOrder(flags []ldapi.FeatureFlag, graph prerequisiteGraph) []ldapi.FeatureFlag {
+	selected := map[string]ldapi.FeatureFlag{}
+	for _, flag := range flags {
+		selected[flag.Key] = flag
+	}
+
+	ordered := []ldapi.FeatureFlag{}
+	added := map[string]bool{}
+	for _, flag := range flags {
+		if added[flag.Key] {
+			continue
+		}
+		for _, member := range graph.cluster(flag.Key) {
+			if memberFlag, isSelected := selected[member]; isSelected && !added[member] {
+				added[member] = true
+				ordered = append(ordered, memberFlag)
+			}
+		}
+	}
+
+	return ordered
+}
+
+// Helper function to get a list without one of its values
+func without(s []string, str string) []string {
+	result := []string{}
+	for _, v := range s {
+		if v != str {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// Helper function to get the context kinds that a flag's individual targets, rule clauses and rollouts refer to in an
+// environment
+func flagContextKinds(flag ldapi.FeatureFlag, env string) []string {
+	kinds := []string{}
+	add := func(kind *string) {
+		k := userKind
+		if kind != nil {
+			k = *kind
+		}
+		if !contains(kinds, k) {
+			kinds = append(kinds, k)
+		}
+	}
+	rollout := func(rollout *ldapi.Rollout) {
+		if rollout != nil {
+			add(rollout.ContextKind)
+		}
+	}
+
+	flagConfig, ok := flag.Environments[env]
+	if !ok {
+		return kinds
+	}
+	for _, target := range flagConfig.Targets {
+		add(target.ContextKind)
+	}
+	for _, target := range flagConfig.ContextTargets {
+		// Context targets for the user kind only mark the position of the user targets, and hold no keys
+		if len(target.Values) > 0 {
+			add(target.ContextKind)
+		}
+	}
+	for _, rule := range flagConfig.Rules {
+		for _, clause := range rule.Clauses {
+			if clause.Op != "segmentMatch" && !contains(attributesToIgnore, clause.Attribute) {
+				add(clause.ContextKind)
+			}
+		}
+		rollout(rule.Rollout)
+	}
+	if flagConfig.Fallthrough != nil {
+		rollout(flagConfig.Fallthrough.Rollout)
+	}
+
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Helper function to describe the context kinds a flag targets
+func describeKinds(kinds []string) string {
+	if len(kinds) == 0 {
+		return "no context kinds"
+	} else if contains(kinds, userKind) {
+		return "targets users: " + strings.Join(kinds, ", ")
+	}
+	return "doesn't target users: " + strings.Join(kinds, ", ")
+}
+
+// PrerequisitesReport lists the prerequisites and dependents of every flag in the report environments, with the
+// context kinds each of them targets. Prerequisite clusters where some flags still target users and others have moved
+// to other context kinds are listed again at the end.
+func PrerequisitesReport() {
+	Configure()
+	requireAPIKey()
+
+	for i, env := range reportEnvKeys {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		flags := getFlags(env).Items
+		graph := buildPrerequisiteGraph(flags, env)
+		kinds := map[string][]string{}
+		for _, flag := range flags {
+			kinds[flag.Key] = flagContextKinds(flag, env)
+		}
+		describe := func(flagKeys []string) string {
+			if len(flagKeys) == 0 {
+				return "none"
+			}
+			described := []string{}
+			for _, flagKey := range flagKeys {
+				if _, exists := graph.flags[flagKey]; !exists {
+					described = append(described, fmt.Sprintf("%v (not found)", flagKey))
+				} else {
+					described = append(described, fmt.Sprintf("%v (%v)", flagKey, describeKinds(kinds[flagKey])))
+				}
+			}
+			return strings.Join(described, ", ")
+		}
+
+		fmt.Printf("Prerequisites of flags in project '%v' and environment '%v':\n", projectKey, env)
+
+		reported := 0
+		mixed := [][]string{}
+		checked := map[string]bool{}
+		for _, flag := range flags {
+			if len(flagKeys) > 0 && !contains(flagKeys, flag.Key) {
+				continue
+			}
+			prerequisites := graph.prerequisites[flag.Key]
+			dependents := graph.dependents[flag.Key]
+			if len(prerequisites) == 0 && len(dependents) == 0 {
+				continue
+			}
+
+			reported++
+			fmt.Println()
+			fmt.Printf("Flag '%v' (%v)\n", flag.Key, describeKinds(kinds[flag.Key]))
+			fmt.Printf("  Prerequisites: %v\n", describe(prerequisites))
+			fmt.Printf("  Dependents: %v\n", describe(dependents))
+
+			if checked[flag.Key] {
+				continue
+			}
+			cluster := graph.cluster(flag.Key)
+			targetsUsers := false
+			targetsOthers := false
+			for _, member := range cluster {
+				checked[member] = true
+				if contains(kinds[member], userKind) {
+					targetsUsers = true
+				} else if len(kinds[member]) > 0 {
+					targetsOthers = true
+				}
+			}
+			if targetsUsers && targetsOthers {
+				mixed = append(mixed, cluster)
+			}
+		}
+
+		fmt.Println()
+		fmt.Printf("%v flag(s) have prerequisites or dependents.\n", reported)
+		fmt.Printf("%v prerequisite cluster(s) mix flags that target users with flags that only target other context kinds", len(mixed))
+		if len(mixed) == 0 {
+			fmt.Println(".")
+			continue
+		}
+		fmt.Println(":")
+		for _, cluster := range mixed {
+			users := []string{}
+			others := []string{}
+			for _, member := range cluster {
+				if contains(kinds[member], userKind) {
+					users = append(users, member)
+				} else if len(kinds[member]) > 0 {
+					others = append(others, member)
+				}
+			}
+			fmt.Printf(" - %v: %v target users; %v don't\n", strings.Join(cluster, ", "), strings.Join(users, ", "), strings.Join(others, ", "))
+		}
+	}
+}
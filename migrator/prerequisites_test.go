@@ -0,0 +1,133 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+// Helper function to build a flag that targets users and has prerequisites in the migration environment
+func prerequisiteFlag(key string, prerequisites ...string) ldapi.FeatureFlag {
+	config := ldapi.FeatureFlagConfig{
+		Targets: []ldapi.Target{{Values: []string{"user-1"}, ContextKind: strPtr(userKind)}},
+	}
+	for _, prerequisite := range prerequisites {
+		config.Prerequisites = append(config.Prerequisites, ldapi.Prerequisite{Key: prerequisite})
+	}
+	return ldapi.FeatureFlag{Key: key, Variations: []ldapi.Variation{{}}, Environments: map[string]ldapi.FeatureFlagConfig{envKey: config}}
+}
+
+func TestCheckPrerequisiteCluster(t *testing.T) {
+	savedRepos, savedClusters := repos, clusters
+	t.Cleanup(func() { repos, clusters = savedRepos, savedClusters })
+
+	a := prerequisiteFlag("a")
+	b := prerequisiteFlag("b", "a")
+	c := prerequisiteFlag("c", "b")
+	graph := buildPrerequisiteGraph([]ldapi.FeatureFlag{a, b, c}, envKey)
+
+	tests := []struct {
+		name       string
+		repos      []string
+		selected   []string
+		unsafe     []string
+		violations int
+	}{
+		{"without repositories", nil, []string{"a", "b"}, []string{"b"}, 2},
+		{"whole cluster safe", []string{"web"}, []string{"a", "b", "c"}, nil, 0},
+		{"unsafe member", []string{"web"}, []string{"a", "b", "c"}, []string{"c"}, 1},
+		{"unselected member", []string{"web"}, []string{"a", "b"}, nil, 1},
+		{"unsafe and unselected members", []string{"web"}, []string{"a", "b"}, []string{"b"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos = tt.repos
+			allDetails := map[string]flagDetails{}
+			for _, key := range tt.selected {
+				details := userReferences(graph.flags[key])
+				if contains(tt.unsafe, key) {
+					details.guardrailMessages = []string{"  unsafe"}
+				}
+				allDetails[key] = details
+			}
+			prepareClusters(graph, allDetails)
+
+			if got := allDetails["a"].cluster; len(got) != 2 {
+				t.Errorf("prepareClusters() linked a to %v, want b and c", got)
+			}
+			violations, err := checkPrerequisiteCluster(ctx, a)
+			if err != nil || len(violations) != tt.violations {
+				t.Errorf("checkPrerequisiteCluster() = %v, %v, want %v violation(s)", violations, err, tt.violations)
+			}
+		})
+	}
+}
+
+func TestRunGuardrailsWithoutRepositories(t *testing.T) {
+	savedRepos, savedAuto, savedNames, savedClusters := repos, autoRepos, guardrailNames, clusters
+	t.Cleanup(func() { repos, autoRepos, guardrailNames, clusters = savedRepos, savedAuto, savedNames, savedClusters })
+	repos, autoRepos, guardrailNames = nil, false, nil
+
+	a := prerequisiteFlag("a")
+	b := prerequisiteFlag("b", "a")
+	prepareClusters(buildPrerequisiteGraph([]ldapi.FeatureFlag{a, b}, envKey), map[string]flagDetails{"a": userReferences(a)})
+
+	results := runGuardrails(a, true)
+	if len(results) != 1 || results[0].name != clusterGuardrailName || !results[0].blocks() {
+		t.Errorf("runGuardrails() = %+v, want the %v guardrail to block a without b", results, clusterGuardrailName)
+	}
+}
+
+func TestFlagContextKinds(t *testing.T) {
+	config := ldapi.FeatureFlagConfig{
+		Targets:        []ldapi.Target{{Values: []string{"user-1"}}},
+		ContextTargets: []ldapi.Target{{ContextKind: strPtr(userKind)}, {Values: []string{"a-1"}, ContextKind: strPtr("account")}},
+		Rules: []ldapi.Rule{{
+			Clauses: []ldapi.Clause{
+				{Attribute: "segmentMatch", Op: "segmentMatch", Values: []interface{}{"beta"}},
+				{Attribute: "os", ContextKind: strPtr("device"), Op: "in", Values: []interface{}{"ios"}},
+			},
+		}},
+		Fallthrough: &ldapi.VariationOrRolloutRep{Rollout: &ldapi.Rollout{ContextKind: strPtr("organization")}},
+	}
+	contextOnly := ldapi.FeatureFlagConfig{ContextTargets: []ldapi.Target{{ContextKind: strPtr(userKind)}, {Values: []string{"a-1"}, ContextKind: strPtr("account")}}}
+	flag := ldapi.FeatureFlag{Key: "flag", Environments: map[string]ldapi.FeatureFlagConfig{"production": config, "staging": contextOnly}}
+
+	tests := []struct {
+		env  string
+		want []string
+	}{
+		{"production", []string{"account", "device", "organization", userKind}},
+		{"staging", []string{"account"}},
+		{"test", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			if got := flagContextKinds(flag, tt.env); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("flagContextKinds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribeKinds(t *testing.T) {
+	tests := []struct {
+		kinds []string
+		want  string
+	}{
+		{nil, "no context kinds"},
+		{[]string{"account", userKind}, "targets users: account, user"},
+		{[]string{"account"}, "doesn't target users: account"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := describeKinds(tt.kinds); got != tt.want {
+				t.Errorf("describeKinds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
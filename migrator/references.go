@@ -0,0 +1,108 @@
+package migrator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Attributes can be referred to by a literal name, such as `zipCode`, or by a slash-delimited reference into nested
+// JSON objects, such as `/address/zip`. In a reference, '~1' stands for a '/' and '~0' stands for a '~' within a
+// component, so the literal name `a/b` is the same attribute as the reference `/a~1b`.
+
+// Split an attribute name or reference into its unescaped path components
+func parseAttributeRef(attribute string) ([]string, error) {
+	if attribute == "" {
+		return nil, fmt.Errorf("it's empty")
+	}
+	if !strings.HasPrefix(attribute, "/") {
+		return []string{attribute}, nil
+	}
+
+	components := strings.Split(attribute[1:], "/")
+	for i, component := range components {
+		if component == "" {
+			return nil, fmt.Errorf("'%v' has an empty path component", attribute)
+		}
+
+		var unescaped strings.Builder
+		for j := 0; j < len(component); j++ {
+			if component[j] != '~' {
+				unescaped.WriteByte(component[j])
+				continue
+			}
+			if j+1 == len(component) || (component[j+1] != '0' && component[j+1] != '1') {
+				return nil, fmt.Errorf("'%v' has a '~' that isn't followed by '0' or '1'", attribute)
+			}
+			if component[j+1] == '0' {
+				unescaped.WriteByte('~')
+			} else {
+				unescaped.WriteByte('/')
+			}
+			j++
+		}
+		components[i] = unescaped.String()
+	}
+
+	return components, nil
+}
+
+// Join path components into an attribute reference, escaping '~' and '/' within each component
+func escapeAttributeRef(components []string) string {
+	escaper := strings.NewReplacer("~", "~0", "/", "~1")
+
+	var ref strings.Builder
+	for _, component := range components {
+		ref.WriteString("/")
+		ref.WriteString(escaper.Replace(component))
+	}
+	return ref.String()
+}
+
+// Helper function to get the form of a destination attribute that's written into clauses and rollouts. A literal name
+// that contains '/' or '~' is escaped into a single-component reference, so that it can't be read as a nested
+// reference. Other names and references are written as they are.
+func emittedAttribute(attribute string) string {
+	if strings.HasPrefix(attribute, "/") || !strings.ContainsAny(attribute, "/~") {
+		return attribute
+	}
+	return escapeAttributeRef([]string{attribute})
+}
+
+// Helper function to get a single form for all of the ways an attribute can be written, so that `name`, `/name` and
+// `a/b`, `/a~1b` compare as equal. Invalid references are returned unchanged.
+func canonicalAttribute(attribute string) string {
+	components, err := parseAttributeRef(attribute)
+	if err != nil {
+		return attribute
+	}
+	return escapeAttributeRef(components)
+}
+
+// Returns true if both names or references refer to the same attribute
+func sameAttribute(a string, b string) bool {
+	return a == b || canonicalAttribute(a) == canonicalAttribute(b)
+}
+
+// Helper function to look up a user attribute in a set of mappings, whichever way either of them is written
+func lookupMapping(mappings map[string]attributeSchema, userAttribute string) (attributeSchema, bool) {
+	if mapping, isMapped := mappings[userAttribute]; isMapped {
+		return mapping, true
+	}
+
+	for name, mapping := range mappings {
+		if sameAttribute(name, userAttribute) {
+			return mapping, true
+		}
+	}
+	return attributeSchema{}, false
+}
+
+// Returns true if any of the names or references refers to the attribute
+func containsAttribute(attributes []string, attribute string) bool {
+	for _, a := range attributes {
+		if sameAttribute(a, attribute) {
+			return true
+		}
+	}
+	return false
+}
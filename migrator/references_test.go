@@ -0,0 +1,175 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+func TestParseAttributeRef(t *testing.T) {
+	tests := []struct {
+		attribute string
+		want      []string
+		wantErr   bool
+	}{
+		{"zipCode", []string{"zipCode"}, false},
+		{"a/b", []string{"a/b"}, false},
+		{"/address/zip", []string{"address", "zip"}, false},
+		{"/a~1b", []string{"a/b"}, false},
+		{"/a~0b", []string{"a~b"}, false},
+		{"/a~01", []string{"a~1"}, false},
+		{"", nil, true},
+		{"/", nil, true},
+		{"/a//b", nil, true},
+		{"/a~2", nil, true},
+		{"/a~", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.attribute, func(t *testing.T) {
+			got, err := parseAttributeRef(tt.attribute)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAttributeRef() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAttributeRef() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscapeAttributeRef(t *testing.T) {
+	tests := []struct {
+		components []string
+		want       string
+	}{
+		{[]string{"zipCode"}, "/zipCode"},
+		{[]string{"address", "zip"}, "/address/zip"},
+		{[]string{"a/b"}, "/a~1b"},
+		{[]string{"a~b"}, "/a~0b"},
+		{[]string{"~1"}, "/~01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := escapeAttributeRef(tt.components); got != tt.want {
+				t.Errorf("escapeAttributeRef() = %v, want %v", got, tt.want)
+			}
+			if parsed, err := parseAttributeRef(tt.want); err != nil || !reflect.DeepEqual(parsed, tt.components) {
+				t.Errorf("parseAttributeRef(%v) = %#v, %v, want %#v", tt.want, parsed, err, tt.components)
+			}
+		})
+	}
+}
+
+func TestSameAttribute(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"name", "name", true},
+		{"name", "/name", true},
+		{"a/b", "/a~1b", true},
+		{"a~b", "/a~0b", true},
+		{"/address/zip", "/address/zip", true},
+		{"/address/zip", "zip", false},
+		{"a/b", "/a/b", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if got := sameAttribute(tt.a, tt.b); got != tt.want {
+				t.Errorf("sameAttribute(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupMapping(t *testing.T) {
+	mappings := map[string]attributeSchema{
+		"/address/zip": {Kind: "location", Attribute: "zip"},
+		"org/id":       {Kind: "organization", Attribute: "key"},
+	}
+
+	tests := []struct {
+		attribute string
+		wantKind  string
+		wantFound bool
+	}{
+		{"/address/zip", "location", true},
+		{"/org~1id", "organization", true},
+		{"org/id", "organization", true},
+		{"address", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.attribute, func(t *testing.T) {
+			got, found := lookupMapping(mappings, tt.attribute)
+			if found != tt.wantFound || got.Kind != tt.wantKind {
+				t.Errorf("lookupMapping(%v) = %v, %v, want kind %v, %v", tt.attribute, got.Kind, found, tt.wantKind, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestEmittedAttribute(t *testing.T) {
+	tests := []struct {
+		attribute string
+		want      string
+	}{
+		{"zipCode", "zipCode"},
+		{"/address/zip", "/address/zip"},
+		{"/a~1b", "/a~1b"},
+		{"a/b", "/a~1b"},
+		{"a~b", "/a~0b"},
+		{"a/~b", "/a~1~0b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.attribute, func(t *testing.T) {
+			got := emittedAttribute(tt.attribute)
+			if got != tt.want {
+				t.Errorf("emittedAttribute() = %v, want %v", got, tt.want)
+			}
+			if !sameAttribute(got, tt.attribute) {
+				t.Errorf("emittedAttribute() = %v, which isn't the same attribute as %v", got, tt.attribute)
+			}
+		})
+	}
+}
+
+func TestDestinationClauseEscapesLiteralNames(t *testing.T) {
+	clause := ldapi.Clause{Attribute: "team", Op: "in", Values: []interface{}{"a"}}
+	tests := []struct {
+		attribute string
+		want      string
+	}{
+		{"team", "team"},
+		{"org/team", "/org~1team"},
+		{"/org/team", "/org/team"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.attribute, func(t *testing.T) {
+			destination := clauseDestination{mapping: attributeSchema{Kind: "organization", Attribute: tt.attribute}, values: clause.Values}
+			if got := destinationClause(clause, destination)["attribute"]; got != tt.want {
+				t.Errorf("destinationClause() attribute = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleRolloutEscapesLiteralNames(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{
+		keyAttribute: {Kind: "organization", Attribute: "org/key"},
+	})
+	flag := ldapi.FeatureFlag{Key: "rollout-flag"}
+	instruction := handleRollout(flag, &ldapi.Rollout{}, nil)
+	if instruction == nil {
+		t.Fatal("handleRollout() = nil, want an instruction")
+	}
+	if got := (*instruction)["rolloutBucketBy"]; got != "/org~1key" {
+		t.Errorf("handleRollout() rolloutBucketBy = %v, want /org~1key", got)
+	}
+}
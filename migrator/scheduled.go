@@ -0,0 +1,459 @@
+package migrator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+// Migrate the semantic patch instructions of a scheduled change or workflow stage the same way as the flag's current
+// targeting. Instructions that don't refer to the user context kind, and user attributes without a mapping, are left
+// unchanged. Returns an error when an instruction can't be migrated automatically.
+func migrateInstructions(flag ldapi.FeatureFlag, instructions []map[string]interface{}) ([]map[string]interface{}, error) {
+	migrated := []map[string]interface{}{}
+	for _, instruction := range instructions {
+		result, err := migrateInstruction(flag, instruction)
+		if err != nil {
+			return nil, err
+		}
+		migrated = append(migrated, result...)
+	}
+	return migrated, nil
+}
+
+func migrateInstruction(flag ldapi.FeatureFlag, instruction map[string]interface{}) ([]map[string]interface{}, error) {
+	original := instruction
+	instruction = copyInstruction(instruction)
+	kind, _ := instruction["kind"].(string)
+
+	switch kind {
+	case "addUserTargets", "removeUserTargets":
+		instruction["kind"] = interface{}(strings.Replace(kind, "UserTargets", "Targets", 1))
+		instruction["contextKind"] = interface{}(userKind)
+		migrated, err := migrateTargets(flag, instruction)
+		if err == nil && len(migrated) == 1 && migrated[0]["contextKind"] == userKind {
+			// The targets aren't mapped, so the instruction is kept as it was
+			return []map[string]interface{}{original}, nil
+		}
+		return migrated, err
+	case "addTargets", "removeTargets":
+		return migrateTargets(flag, instruction)
+	case "replaceUserTargets":
+		return nil, fmt.Errorf("'%v' replaces only the user targets, and there's no instruction that replaces only the targets of the mapped context kinds", kind)
+	case "replaceTargets":
+		targets := []interface{}{}
+		for _, target := range toMaps(instruction["targets"]) {
+			target["kind"] = interface{}("addTargets")
+			migratedTargets, err := migrateTargets(flag, target)
+			if err != nil {
+				return nil, err
+			}
+			for _, migratedTarget := range migratedTargets {
+				delete(migratedTarget, "kind")
+				targets = append(targets, migratedTarget)
+			}
+		}
+		instruction["targets"] = interface{}(targets)
+	case "addRule":
+		return migrateRule(flag, instruction)
+	case "addClauses":
+		clauses := []interface{}{}
+		for _, clause := range toMaps(instruction["clauses"]) {
+			migratedClause, err := migrateSingleClause(flag, clause)
+			if err != nil {
+				return nil, err
+			}
+			clauses = append(clauses, migratedClause)
+		}
+		instruction["clauses"] = interface{}(clauses)
+	case "updateClause":
+		if clause, isMap := instruction["clause"].(map[string]interface{}); isMap {
+			migratedClause, err := migrateSingleClause(flag, clause)
+			if err != nil {
+				return nil, err
+			}
+			instruction["clause"] = interface{}(migratedClause)
+		}
+	case "replaceRules":
+		rules := []interface{}{}
+		for _, rule := range toMaps(instruction["rules"]) {
+			migratedRules, err := migrateRule(flag, rule)
+			if err != nil {
+				return nil, err
+			}
+			for _, migratedRule := range migratedRules {
+				rules = append(rules, migratedRule)
+			}
+		}
+		instruction["rules"] = interface{}(rules)
+	case updateRuleVarOrRollout:
+		migrateRolloutFields(flag, instruction, "a rule")
+	case updateFallthroughVarOrRollout:
+		migrateRolloutFields(flag, instruction, "the fallthrough")
+	}
+
+	return []map[string]interface{}{instruction}, nil
+}
+
+// Migrate an instruction that adds or removes individual user targets. Individual targets can only hold context keys,
+// so the targets can only move to destinations where the user key maps to a key attribute.
+func migrateTargets(flag ldapi.FeatureFlag, instruction map[string]interface{}) ([]map[string]interface{}, error) {
+	if contextKind, _ := instruction["contextKind"].(string); contextKind != userKind {
+		return []map[string]interface{}{instruction}, nil
+	}
+
+	values, _ := instruction["values"].([]interface{})
+	destinations := splitValues(flag, keyAttribute, values)
+	if len(destinations) == 0 {
+		fmt.Printf("  Skipping individual user targets because no '%v' mapping was provided.\n", keyAttribute)
+		return []map[string]interface{}{instruction}, nil
+	}
+
+	migrated := []map[string]interface{}{}
+	for _, destination := range destinations {
+		mapping := destination.mapping
+		if !sameAttribute(mapping.Attribute, keyAttribute) {
+			return nil, fmt.Errorf("individual user targets would move to '%v' attribute '%v', which individual targets can't hold", mapping.Kind, mapping.Attribute)
+		}
+		target := copyInstruction(instruction)
+		target["contextKind"] = interface{}(mapping.Kind)
+		target["values"] = interface{}(transformKeys(keyAttribute, toStrings(destination.values), mapping))
+		migrated = append(migrated, target)
+	}
+	return migrated, nil
+}
+
+// Migrate a rule that an instruction adds, the same way as the flag's current rules. Its clauses and rollout are
+// mapped, and the rules that follow it are added directly below it: a copy for each other combination of context
+// kinds that its clause values map to, and a copy that keeps the behavior of a negated clause that moves to another
+// kind. Rules where only some user attributes are mapped follow PARTIAL_RULE_POLICY.
+func migrateRule(flag ldapi.FeatureFlag, instruction map[string]interface{}) ([]map[string]interface{}, error) {
+	rule := ldapi.Rule{}
+	for _, clause := range toMaps(instruction["clauses"]) {
+		rule.Clauses = append(rule.Clauses, toClause(clause))
+	}
+	if _, partial := ruleKinds(flag, rule); partial {
+		if partialRulePolicy == partialRuleSkipFlag {
+			return nil, fmt.Errorf("it adds a rule where only some user attributes are mapped, and PARTIAL_RULE_POLICY is %v", partialRuleSkipFlag)
+		} else if skipPartialRule("a rule that it adds") {
+			return []map[string]interface{}{instruction}, nil
+		}
+	}
+
+	migrateRolloutFields(flag, instruction, "a rule")
+
+	alternatives := [][]map[string]interface{}{}
+	for _, clause := range toMaps(instruction["clauses"]) {
+		alternatives = append(alternatives, migrateClause(flag, clause))
+	}
+
+	migrated := []map[string]interface{}{}
+	description, _ := instruction["description"].(string)
+	for i, combination := range combinations(alternatives) {
+		copied := copyInstruction(instruction)
+		copied["clauses"] = interface{}(combination)
+		if i > 0 {
+			// Rule references must be unique, so only the first rule keeps it
+			delete(copied, "ref")
+			copied["description"] = interface{}(strings.TrimSpace(description + " (migrated copy)"))
+		}
+		migrated = append(migrated, copied)
+	}
+
+	if clauses, negatedDescription := negatedClauseRewrite(flag, rule.Clauses); clauses != nil {
+		copied := copyInstruction(instruction)
+		delete(copied, "ref")
+		copied["clauses"] = interface{}(clauses)
+		copied["description"] = interface{}(negatedDescription)
+		migrated = append(migrated, copied)
+	}
+	return migrated, nil
+}
+
+// Migrate a clause that can't be split across context kinds
+func migrateSingleClause(flag ldapi.FeatureFlag, clause map[string]interface{}) (map[string]interface{}, error) {
+	migrated := migrateClause(flag, clause)
+	if len(migrated) > 1 {
+		return nil, fmt.Errorf("the values of the clause for user attribute '%v' map to more than one context kind, so the clause would have to be split into several rules", clause["attribute"])
+	}
+	return migrated[0], nil
+}
+
+// Migrate a clause of an instruction to each of its destinations, the same way as the flag's rule clauses. A clause
+// that isn't mapped is left as it was.
+func migrateClause(flag ldapi.FeatureFlag, clauseMap map[string]interface{}) []map[string]interface{} {
+	clauses, mapped := mapClause(flag, toClause(clauseMap))
+	if !mapped {
+		return []map[string]interface{}{clauseMap}
+	}
+	return clauses
+}
+
+// Helper function to get the clause that an instruction's clause object describes
+func toClause(clauseMap map[string]interface{}) ldapi.Clause {
+	clause := ldapi.Clause{}
+	clause.Attribute, _ = clauseMap["attribute"].(string)
+	clause.Op, _ = clauseMap["op"].(string)
+	clause.Negate, _ = clauseMap["negate"].(bool)
+	clause.Values, _ = clauseMap["values"].([]interface{})
+	if contextKind, isString := clauseMap["contextKind"].(string); isString {
+		clause.ContextKind = &contextKind
+	}
+	return clause
+}
+
+// Migrate the rollout fields of an instruction or rule in place, if it has a rollout for the user context kind
+func migrateRolloutFields(flag ldapi.FeatureFlag, fields map[string]interface{}, rolloutType string) {
+	if _, hasRollout := fields["rolloutWeights"]; !hasRollout {
+		return
+	}
+
+	contextKind, _ := fields["rolloutContextKind"].(string)
+	bucketBy, _ := fields["rolloutBucketBy"].(string)
+	if kind, attribute, moved := migrateRollout(flag, rolloutType, &contextKind, &bucketBy); moved {
+		fields["rolloutContextKind"] = interface{}(kind)
+		fields["rolloutBucketBy"] = interface{}(attribute)
+	}
+}
+
+// Helper function to get a copy of an instruction, so that migrating it doesn't change the original
+func copyInstruction(instruction map[string]interface{}) map[string]interface{} {
+	copied := map[string]interface{}{}
+	for key, value := range instruction {
+		copied[key] = value
+	}
+	return copied
+}
+
+// Helper function to get copies of the objects in an instruction's list field
+func toMaps(value interface{}) []map[string]interface{} {
+	maps := []map[string]interface{}{}
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if m, isMap := item.(map[string]interface{}); isMap {
+				maps = append(maps, copyInstruction(m))
+			}
+		}
+	case []map[string]interface{}:
+		for _, m := range v {
+			maps = append(maps, copyInstruction(m))
+		}
+	}
+	return maps
+}
+
+// Returns true if migrating the instructions changed any of them
+func instructionsChanged(original []map[string]interface{}, migrated []map[string]interface{}) bool {
+	originalJSON, _ := json.Marshal(original)
+	migratedJSON, _ := json.Marshal(migrated)
+	return string(originalJSON) != string(migratedJSON)
+}
+
+// Prepare approval requests that migrate the flag's scheduled changes, so that they don't add user targeting back
+// after the migration. Returns the number of scheduled changes that are migrated.
+func migrateScheduledChanges(flag ldapi.FeatureFlag, details flagDetails) int {
+	changes, err := pendingScheduledChanges(ctx, flag)
+	if err != nil {
+		fmt.Printf("  Warning: the flag's scheduled changes couldn't be checked: %v.\n", err)
+		return 0
+	}
+	return migrateChanges(flag, details, changes)
+}
+
+func migrateChanges(flag ldapi.FeatureFlag, details flagDetails, changes []ldapi.FeatureFlagScheduledChange) int {
+	numMigrated := 0
+	for _, change := range changes {
+		migrated, err := migrateInstructions(flag, change.Instructions)
+		if err != nil {
+			fmt.Printf("  Warning: %v changes user targeting and must be migrated by hand: %v.\n", scheduledChangeLabel(change), err)
+			continue
+		}
+		if !instructionsChanged(change.Instructions, migrated) {
+			fmt.Printf("  Skipping %v because none of its user targeting is mapped.\n", scheduledChangeLabel(change))
+			continue
+		}
+
+		numMigrated++
+		fmt.Printf("  Adding an approval request to migrate %v.\n", scheduledChangeLabel(change))
+		if migrate {
+			description := fmt.Sprintf("Migrating the scheduled change for %v on %v to use custom contexts.", formatTimestamp(change.ExecutionDate), flag.Key)
+			instructions := []map[string]interface{}{{
+				"kind":  interface{}("replaceScheduledChangesInstructions"),
+				"value": interface{}(migrated),
+			}}
+			submitApproval(flag, details, description, instructions, &change.Id)
+		}
+	}
+	return numMigrated
+}
+
+// Recreate the flag's active workflows that have stages that haven't run yet and change user targeting, with the
+// instructions of those stages migrated the same way as scheduled changes. Returns the number of workflows that are
+// migrated.
+func migrateWorkflows(flag ldapi.FeatureFlag, details flagDetails) int {
+	stages, err := pendingWorkflowStages(ctx, flag)
+	if errors.Is(err, errWorkflowsUnavailable) {
+		return 0
+	} else if err != nil {
+		fmt.Printf("  Warning: the flag's workflows couldn't be checked: %v.\n", err)
+		return 0
+	}
+	return migrateWorkflowStages(flag, details, stages)
+}
+
+func migrateWorkflowStages(flag ldapi.FeatureFlag, details flagDetails, stages []workflowStage) int {
+	numMigrated := 0
+	seen := map[string]bool{}
+	for _, pending := range stages {
+		workflow := pending.workflow
+		if seen[workflow.Id] {
+			continue
+		}
+		seen[workflow.Id] = true
+
+		migrated, numStages, err := migrateWorkflow(flag, details, workflow)
+		if err != nil {
+			fmt.Printf("  Warning: %v.\n", err)
+			continue
+		}
+		if numStages == 0 {
+			fmt.Printf("  Skipping %v because none of its user targeting is mapped.\n", workflowLabel(workflow))
+			continue
+		}
+
+		numMigrated++
+		fmt.Printf("  Recreating %v with %v migrated stage(s).\n", workflowLabel(workflow), numStages)
+		if migrate {
+			replaceWorkflow(flag, workflow, migrated)
+		}
+	}
+	return numMigrated
+}
+
+// Build a copy of a workflow with the stages that haven't run yet, where the instructions of each stage that changes
+// user targeting are migrated. Workflows can't be edited, so the copy replaces the workflow. The reviews of the
+// workflow's approval conditions aren't copied, and each migrated stage gets an approval condition for the flag's
+// maintainer, so that the migrated instructions are reviewed before they run. Returns the copy and the number of
+// stages that are migrated.
+func migrateWorkflow(flag ldapi.FeatureFlag, details flagDetails, workflow ldapi.CustomWorkflowOutput) (ldapi.CustomWorkflowInput, int, error) {
+	migrated := *ldapi.NewCustomWorkflowInput(workflow.Name)
+	migrated.Description = workflow.Description
+	if workflow.MaintainerId != "" {
+		migrated.MaintainerId = ldapi.PtrString(workflow.MaintainerId)
+	}
+
+	numMigrated := 0
+	for _, stage := range workflow.Stages {
+		if contains(finishedStageStatuses, stage.Execution.Status) {
+			continue
+		}
+
+		conditions := []ldapi.ConditionInput{}
+		for _, condition := range stage.Conditions {
+			conditions = append(conditions, toConditionInput(condition))
+		}
+		instructions := stage.Action.Instructions
+		if instructionsReferenceUsers(instructions) {
+			migratedInstructions, err := migrateInstructions(flag, instructions)
+			if err != nil {
+				return migrated, 0, fmt.Errorf("the flag's %v changes user targeting, so the workflow must be migrated by hand: %v", workflowStageLabel(workflowStage{workflow, stage}), err)
+			}
+			if instructionsChanged(instructions, migratedInstructions) {
+				numMigrated++
+				instructions = migratedInstructions
+				conditions = append(conditions, migrationReviewCondition(flag, details))
+			}
+		}
+
+		migrated.Stages = append(migrated.Stages, ldapi.StageInput{
+			Name:       stage.Name,
+			Conditions: conditions,
+			Action:     &ldapi.ActionInput{Instructions: instructions},
+		})
+	}
+	return migrated, numMigrated, nil
+}
+
+// Helper function to copy a condition of a workflow stage, without its reviews
+func toConditionInput(condition ldapi.ConditionOutput) ldapi.ConditionInput {
+	input := ldapi.ConditionInput{
+		Kind:             condition.Kind,
+		ScheduleKind:     condition.ScheduleKind,
+		ExecutionDate:    condition.ExecutionDate,
+		WaitDuration:     condition.WaitDuration,
+		WaitDurationUnit: condition.WaitDurationUnit,
+		NotifyMemberIds:  condition.NotifyMemberIds,
+	}
+	if condition.Description != "" {
+		input.Description = ldapi.PtrString(condition.Description)
+	}
+	return input
+}
+
+// Helper function to get an approval condition that asks the flag's maintainer to review a migrated workflow stage
+func migrationReviewCondition(flag ldapi.FeatureFlag, details flagDetails) ldapi.ConditionInput {
+	condition := ldapi.ConditionInput{
+		Kind:        ldapi.PtrString("approval"),
+		Description: ldapi.PtrString(fmt.Sprintf("Review this stage's instructions, which were migrated to use custom contexts along with %v.", flag.Key)),
+	}
+	condition.NotifyMemberIds, condition.NotifyTeamKeys = approvalReviewers(details)
+	return condition
+}
+
+// Create the migrated copy of a workflow, and delete the workflow once its copy exists, so that its stages don't run
+// twice
+func replaceWorkflow(flag ldapi.FeatureFlag, workflow ldapi.CustomWorkflowOutput, migrated ldapi.CustomWorkflowInput) {
+	created, r, err := client.WorkflowsBetaApi.PostWorkflow(ctx, projectKey, flag.Key, envKey).CustomWorkflowInput(migrated).Execute()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when calling `WorkflowsBetaApi.PostWorkflow`: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
+		return
+	}
+
+	r, err = client.WorkflowsBetaApi.DeleteWorkflow(ctx, projectKey, flag.Key, envKey, workflow.Id).Execute()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when calling `WorkflowsBetaApi.DeleteWorkflow`: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
+		fmt.Printf("  Warning: %v was recreated as %v, but couldn't be deleted. Delete it by hand so that its stages don't run.\n", workflowLabel(workflow), created.Id)
+		return
+	}
+	fmt.Printf("  Workflow '%v' (ID %v) has been recreated as %v with its stages migrated.\n", workflow.Name, workflow.Id, created.Id)
+}
+
+// Check the scheduled changes and workflow stages of a flag that doesn't target users. The flag may have been migrated
+// already, but they can still add user targeting to it. They're migrated when a schema is provided, and reported
+// otherwise. Returns the number of scheduled changes and the number of workflows that are migrated.
+func checkUpcomingUserTargeting(flag ldapi.FeatureFlag, details flagDetails) (int, int) {
+	changes, changesErr := pendingScheduledChanges(ctx, flag)
+	stages, stagesErr := pendingWorkflowStages(ctx, flag)
+	if errors.Is(stagesErr, errWorkflowsUnavailable) {
+		stagesErr = nil
+	}
+	if len(changes) == 0 && len(stages) == 0 && changesErr == nil && stagesErr == nil {
+		return 0, 0
+	}
+
+	fmt.Printf("Flag '%v' doesn't target users, but its scheduled changes or workflows may add user targeting.\n", flag.Key)
+	if changesErr != nil {
+		fmt.Printf("  Warning: the flag's scheduled changes couldn't be checked: %v.\n", changesErr)
+	}
+	if stagesErr != nil {
+		fmt.Printf("  Warning: the flag's workflows couldn't be checked: %v.\n", stagesErr)
+	}
+
+	if len(schema) == 0 {
+		for _, change := range changes {
+			fmt.Printf("  Warning: the flag has %v that changes user targeting. Provide SCHEMA_FILE to migrate it.\n", scheduledChangeLabel(change))
+		}
+		for _, stage := range stages {
+			fmt.Printf("  Warning: the flag's %v changes user targeting. Provide SCHEMA_FILE to migrate it.\n", workflowStageLabel(stage))
+		}
+		return 0, 0
+	}
+	return migrateChanges(flag, details, changes), migrateWorkflowStages(flag, details, stages)
+}
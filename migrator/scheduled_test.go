@@ -0,0 +1,191 @@
+package migrator
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+func TestMigrateRule(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{
+		"email": {Kind: "account", Attribute: "email"},
+	})
+	savedPolicy, savedRewrite := partialRulePolicy, rewriteNegatedClauses
+	t.Cleanup(func() { partialRulePolicy, rewriteNegatedClauses = savedPolicy, savedRewrite })
+	rewriteNegatedClauses = true
+
+	email := map[string]interface{}{"attribute": "email", "op": "in", "values": []interface{}{"a@example.com"}}
+	negatedEmail := map[string]interface{}{"attribute": "email", "op": "in", "values": []interface{}{"a@example.com"}, "negate": true}
+	country := map[string]interface{}{"attribute": "country", "op": "in", "values": []interface{}{"NZ"}}
+
+	tests := []struct {
+		name         string
+		policy       string
+		clauses      []interface{}
+		wantErr      bool
+		wantRules    int
+		wantMigrated bool
+	}{
+		{"mapped clause", partialRuleWarn, []interface{}{email}, false, 1, true},
+		{"negated clause", partialRuleWarn, []interface{}{negatedEmail}, false, 2, true},
+		{"partial rule with warn", partialRuleWarn, []interface{}{email, country}, false, 1, true},
+		{"partial rule with skip-rule", partialRuleSkipRule, []interface{}{email, country}, false, 1, false},
+		{"partial rule with skip-flag", partialRuleSkipFlag, []interface{}{email, country}, true, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			partialRulePolicy = tt.policy
+			instruction := map[string]interface{}{"kind": "addRule", "ref": "rule-1", "clauses": tt.clauses}
+			migrated, err := migrateRule(ldapi.FeatureFlag{Key: "flag"}, instruction)
+			if (err != nil) != tt.wantErr || len(migrated) != tt.wantRules {
+				t.Fatalf("migrateRule() = %v, %v, want %v rule(s)", migrated, err, tt.wantRules)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := toMaps(migrated[0]["clauses"])[0]["contextKind"] == "account"; got != tt.wantMigrated {
+				t.Errorf("migrateRule() clauses = %v, migrated = %v, want %v", migrated[0]["clauses"], got, tt.wantMigrated)
+			}
+			if len(migrated) > 1 {
+				negated := migrated[1]
+				if _, hasRef := negated["ref"]; hasRef || toMaps(negated["clauses"])[0]["attribute"] != "kind" {
+					t.Errorf("migrateRule() negated copy = %v, want a kind clause without a ref", negated)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateRolloutFields(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{
+		keyAttribute: {Kind: "organization", Attribute: "org/key"},
+	})
+
+	fields := map[string]interface{}{"rolloutWeights": map[string]interface{}{"on": 50000, "off": 50000}}
+	migrateRolloutFields(ldapi.FeatureFlag{Key: "flag"}, fields, "the fallthrough")
+	if fields["rolloutContextKind"] != "organization" || fields["rolloutBucketBy"] != "/org~1key" {
+		t.Errorf("migrateRolloutFields() = %v, want the rollout to move to organization /org~1key", fields)
+	}
+
+	fields = map[string]interface{}{"variationId": "on"}
+	migrateRolloutFields(ldapi.FeatureFlag{Key: "flag"}, fields, "the fallthrough")
+	if _, hasKind := fields["rolloutContextKind"]; hasKind {
+		t.Errorf("migrateRolloutFields() = %v, want fields without a rollout unchanged", fields)
+	}
+}
+
+func TestPendingWorkflowStagesUnavailable(t *testing.T) {
+	tests := []struct {
+		name            string
+		status          int
+		wantUnavailable bool
+	}{
+		{"forbidden", http.StatusForbidden, true},
+		{"not found", http.StatusNotFound, true},
+		{"server error", http.StatusInternalServerError, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"code":"error","message":"unavailable"}`))
+			})
+			stages, err := pendingWorkflowStages(ctx, ldapi.FeatureFlag{Key: "flag"})
+			if err == nil || errors.Is(err, errWorkflowsUnavailable) != tt.wantUnavailable || len(stages) != 0 {
+				t.Errorf("pendingWorkflowStages() = %v, %v, want unavailable: %v", stages, err, tt.wantUnavailable)
+			}
+		})
+	}
+}
+
+func TestMigrateWorkflow(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{
+		"email": {Kind: "account", Attribute: "email"},
+	})
+
+	addEmailRule := map[string]interface{}{"kind": "addRule", "clauses": []interface{}{
+		map[string]interface{}{"attribute": "email", "op": "in", "values": []interface{}{"a@example.com"}},
+	}}
+	addCountryRule := map[string]interface{}{"kind": "addRule", "clauses": []interface{}{
+		map[string]interface{}{"attribute": "country", "op": "in", "values": []interface{}{"NZ"}},
+	}}
+	stage := func(name string, status string, instructions ...map[string]interface{}) ldapi.StageOutput {
+		return ldapi.StageOutput{
+			Id:         name,
+			Name:       strPtr(name),
+			Conditions: []ldapi.ConditionOutput{{Kind: strPtr("schedule"), ExecutionDate: ldapi.PtrInt64(1700000000000)}},
+			Action:     ldapi.ActionOutput{Kind: "patch", Instructions: instructions},
+			Execution:  ldapi.ExecutionOutput{Status: status},
+		}
+	}
+	details := flagDetails{maintainerTeamKey: "growth"}
+
+	tests := []struct {
+		name       string
+		stages     []ldapi.StageOutput
+		wantErr    bool
+		wantStages int
+		wantMoved  int
+	}{
+		{"migrated stage", []ldapi.StageOutput{stage("done", "completed", addEmailRule), stage("next", "pending", addEmailRule)}, false, 1, 1},
+		{"stage without mapped targeting", []ldapi.StageOutput{stage("next", "pending", addCountryRule)}, false, 1, 0},
+		{"stage that can't be migrated", []ldapi.StageOutput{stage("next", "pending", map[string]interface{}{"kind": "replaceUserTargets"})}, true, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workflow := ldapi.CustomWorkflowOutput{Id: "w1", Name: "Launch", MaintainerId: "m1", Stages: tt.stages}
+			migrated, numMigrated, err := migrateWorkflow(prerequisiteFlag("flag"), details, workflow)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("migrateWorkflow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(migrated.Stages) != tt.wantStages || numMigrated != tt.wantMoved {
+				t.Fatalf("migrateWorkflow() = %v stage(s), %v migrated, want %v and %v", len(migrated.Stages), numMigrated, tt.wantStages, tt.wantMoved)
+			}
+			if migrated.Name != "Launch" || migrated.MaintainerId == nil || *migrated.MaintainerId != "m1" {
+				t.Errorf("migrateWorkflow() = %+v, want the name and maintainer of the workflow", migrated)
+			}
+
+			conditions := migrated.Stages[0].Conditions
+			instructions := migrated.Stages[0].Action.Instructions.([]map[string]interface{})
+			if tt.wantMoved == 0 {
+				if len(conditions) != 1 || instructionsChanged(instructions, tt.stages[0].Action.Instructions) {
+					t.Errorf("migrateWorkflow() stage = %v, %v, want it unchanged", conditions, instructions)
+				}
+				return
+			}
+			if len(conditions) != 2 || *conditions[1].Kind != "approval" || len(conditions[1].NotifyTeamKeys) != 1 {
+				t.Errorf("migrateWorkflow() conditions = %+v, want the schedule and an approval for the maintainer", conditions)
+			}
+			if toMaps(instructions[0]["clauses"])[0]["contextKind"] != "account" {
+				t.Errorf("migrateWorkflow() instructions = %v, want the email clause to move to account", instructions)
+			}
+		})
+	}
+}
+
+func TestReplaceWorkflow(t *testing.T) {
+	calls := []string{}
+	useAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			w.Write([]byte(`{"_id": "w2", "name": "Launch", "_execution": {"status": "active"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	replaceWorkflow(ldapi.FeatureFlag{Key: "flag"}, ldapi.CustomWorkflowOutput{Id: "w1", Name: "Launch"}, *ldapi.NewCustomWorkflowInput("Launch"))
+	if len(calls) != 2 || calls[0] != http.MethodPost || calls[1] != http.MethodDelete {
+		t.Errorf("replaceWorkflow() called %v, want the copy created before the workflow is deleted", calls)
+	}
+}
@@ -0,0 +1,275 @@
+package migrator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+type attributeSchema struct {
+	Kind       string
+	Attribute  string
+	Type       string
+	Transforms []valueTransform
+	Conditions []conditionalMapping
+	FanOut     []attributeSchema `yaml:"fanOut"`
+}
+
+// A block of mappings that replace or remove the schema's mappings for some environments and flags. When both
+// environments and flags are listed, both must match. Later blocks take precedence over earlier ones.
+type schemaOverride struct {
+	Environments []string
+	Flags        []string
+	Mappings     map[string]attributeSchema
+	Remove       []string
+}
+
+var (
+	schemaOverrides []schemaOverride
+	schemaWarnings  []string
+)
+
+// Context kinds may only contain letters, numbers, '.', '_' and '-'
+var validKind = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+var reservedKinds = []string{
+	"kind",  // Refers to the kinds of the context itself
+	"multi", // Denotes a multi-context
+}
+
+var reservedAttributes = []string{
+	"kind",  // Refers to the context kind and can't hold a mapped value
+	"_meta", // Holds context metadata such as private attributes
+}
+
+func prepareSchema() {
+	if schemaFile == "" {
+		return
+	}
+
+	// Read the schema file provided by the arguments
+
+	file, err := ioutil.ReadFile(schemaFile)
+
+	if err != nil {
+		log.Fatal(err)
+		os.Exit(3)
+	}
+
+	schema, schemaOverrides, err = parseSchema(file)
+
+	if err != nil {
+		log.Fatal(err)
+		os.Exit(4)
+	}
+
+	// Validate the schema
+
+	schemaErrors, warnings := validateSchema(schema, schemaOverrides)
+	schemaWarnings = warnings
+
+	for _, warning := range schemaWarnings {
+		fmt.Printf("Warning: %v\n", warning)
+	}
+	if len(schemaErrors) > 0 {
+		for _, schemaError := range schemaErrors {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", schemaError)
+		}
+		log.Fatalf("The schema file '%v' has %v error(s).", schemaFile, len(schemaErrors))
+		os.Exit(9)
+	}
+
+	// Print the schema
+
+	fmt.Println("Using the following schema mappings:")
+	for userAttribute, newAttribute := range schema {
+		if len(newAttribute.Transforms) > 0 {
+			fmt.Printf("  %s: {%s %s} with %v transform(s)\n", userAttribute, newAttribute.Kind, newAttribute.Attribute, len(newAttribute.Transforms))
+		} else {
+			fmt.Printf("  %s: {%s %s}\n", userAttribute, newAttribute.Kind, newAttribute.Attribute)
+		}
+	}
+	if len(schemaOverrides) > 0 {
+		fmt.Printf("  ... with %v override block(s)\n", len(schemaOverrides))
+	}
+
+	fmt.Println()
+}
+
+// Unmarshal a schema file. Unknown fields are rejected so that a typo such as `kin:` isn't silently ignored. The
+// top-level `overrides` key holds the override blocks, and every other top-level key is a user attribute mapping. A
+// user attribute named `overrides` is mapped with the reference `/overrides`, which lookups treat as the same name.
+func parseSchema(file []byte) (map[string]attributeSchema, []schemaOverride, error) {
+	var document struct {
+		Overrides []schemaOverride
+		Mappings  map[string]attributeSchema `yaml:",inline"`
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(file))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&document); err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, err
+	}
+
+	if document.Mappings == nil {
+		document.Mappings = make(map[string]attributeSchema)
+	}
+	return document.Mappings, document.Overrides, nil
+}
+
+// Check the schema and its overrides for mappings that can't be migrated (errors) and mappings that are likely
+// mistakes (warnings)
+func validateSchema(s map[string]attributeSchema, overrides []schemaOverride) ([]string, []string) {
+	schemaErrors, warnings := validateMappings("", s)
+	warnings = append(warnings, builtinWarnings(s)...)
+
+	for i, override := range overrides {
+		prefix := fmt.Sprintf("Override %v: ", i+1)
+
+		if len(override.Environments) == 0 && len(override.Flags) == 0 {
+			schemaErrors = append(schemaErrors, prefix+"it needs at least one of environments or flags.")
+		}
+		for _, pattern := range override.Flags {
+			if _, err := path.Match(pattern, ""); err != nil {
+				schemaErrors = append(schemaErrors, fmt.Sprintf("%vthe flag pattern '%v' is invalid.", prefix, pattern))
+			}
+		}
+		if len(override.Mappings) == 0 && len(override.Remove) == 0 {
+			warnings = append(warnings, prefix+"it has no mappings to replace or remove.")
+		}
+
+		overrideErrors, overrideWarnings := validateMappings(prefix, override.Mappings)
+		schemaErrors = append(schemaErrors, overrideErrors...)
+		warnings = append(warnings, overrideWarnings...)
+	}
+
+	return schemaErrors, warnings
+}
+
+// Check a set of mappings. The prefix identifies where the mappings are defined.
+func validateMappings(prefix string, s map[string]attributeSchema) ([]string, []string) {
+	schemaErrors := []string{}
+	warnings := []string{}
+
+	userAttributes := make([]string, 0, len(s))
+	for userAttribute := range s {
+		userAttributes = append(userAttributes, userAttribute)
+	}
+	sort.Strings(userAttributes)
+
+	destinations := map[string]string{}
+	sources := map[string]string{}
+	for _, userAttribute := range userAttributes {
+		mapping := s[userAttribute]
+
+		if _, err := parseAttributeRef(userAttribute); err != nil {
+			schemaErrors = append(schemaErrors, fmt.Sprintf("%v'%v' isn't a valid user attribute: %v.", prefix, userAttribute, err))
+		} else if other, isDuplicate := sources[canonicalAttribute(userAttribute)]; isDuplicate {
+			schemaErrors = append(schemaErrors, fmt.Sprintf("%v'%v' and '%v' refer to the same user attribute.", prefix, other, userAttribute))
+		} else {
+			sources[canonicalAttribute(userAttribute)] = userAttribute
+		}
+
+		if err := validateDestination(mapping); err != nil {
+			schemaErrors = append(schemaErrors, fmt.Sprintf("%v'%v' %v.", prefix, userAttribute, err))
+		}
+
+		for i := range mapping.Conditions {
+			if err := validateCondition(&mapping.Conditions[i]); err != nil {
+				schemaErrors = append(schemaErrors, fmt.Sprintf("%v'%v' condition %v %v.", prefix, userAttribute, i+1, err))
+			}
+		}
+
+		for i, fanOut := range mapping.FanOut {
+			if len(fanOut.Conditions) > 0 || len(fanOut.FanOut) > 0 {
+				schemaErrors = append(schemaErrors, fmt.Sprintf("%v'%v' fan-out mapping %v can't have conditions or fan-out mappings of its own.", prefix, userAttribute, i+1))
+			} else if err := validateDestination(fanOut); err != nil {
+				schemaErrors = append(schemaErrors, fmt.Sprintf("%v'%v' fan-out mapping %v %v.", prefix, userAttribute, i+1, err))
+			}
+		}
+
+		destination := mapping.Kind + canonicalAttribute(mapping.Attribute)
+		if other, isDuplicate := destinations[destination]; isDuplicate {
+			warnings = append(warnings, fmt.Sprintf("%v'%v' and '%v' both map to '%v' attribute '%v'.", prefix, other, userAttribute, mapping.Kind, mapping.Attribute))
+		} else {
+			destinations[destination] = userAttribute
+		}
+
+		if sameAttribute(userAttribute, keyAttribute) && !sameAttribute(mapping.Attribute, keyAttribute) {
+			warnings = append(warnings, fmt.Sprintf("%v'%v' maps to '%v' attribute '%v' instead of '%v/%v', so individual targets will become targeting rules.", prefix, keyAttribute, mapping.Kind, mapping.Attribute, mapping.Kind, keyAttribute))
+		}
+	}
+
+	return schemaErrors, warnings
+}
+
+// Validate the kind, attribute, type and transforms that a user attribute maps to
+func validateDestination(mapping attributeSchema) error {
+	if mapping.Kind == "" {
+		return fmt.Errorf("has no kind")
+	} else if err := validateKind(mapping.Kind); err != nil {
+		return fmt.Errorf("has an invalid kind: %v", err)
+	}
+
+	if mapping.Attribute == "" {
+		return fmt.Errorf("has no attribute")
+	} else if err := validateAttribute(mapping.Attribute); err != nil {
+		return fmt.Errorf("has an invalid attribute: %v", err)
+	}
+
+	if err := validateAttributeType(mapping.Type); err != nil {
+		return err
+	}
+
+	if err := validateTransforms(mapping.Transforms); err != nil {
+		return fmt.Errorf("has an invalid transform: %v", err)
+	}
+
+	return nil
+}
+
+func validateKind(kind string) error {
+	if contains(reservedKinds, kind) {
+		return fmt.Errorf("'%v' is a reserved name", kind)
+	}
+	if !validKind.MatchString(kind) {
+		return fmt.Errorf("'%v' may only contain letters, numbers, '.', '_' and '-'", kind)
+	}
+	return nil
+}
+
+// Validate a literal attribute name or a slash-delimited attribute reference that is mapped to
+func validateAttribute(attribute string) error {
+	components, err := parseAttributeRef(attribute)
+	if err != nil {
+		return err
+	}
+
+	if contains(reservedAttributes, components[0]) {
+		return fmt.Errorf("'%v' is reserved and can't be mapped to", components[0])
+	}
+	return nil
+}
+
+// LintSchema validates the schema file without running a migration. The schema is validated as soon as it's
+// loaded, which exits on errors, so reaching this point means that the schema file is valid.
+func LintSchema() {
+	Configure()
+	if schemaFile == "" {
+		log.Fatal("SCHEMA_FILE must be provided to lint the schema.")
+		os.Exit(9)
+	}
+
+	printProposedBuiltinMappings()
+
+	fmt.Printf("The schema file '%v' is valid with %v mapping(s), %v override block(s) and %v warning(s).\n", schemaFile, len(schema), len(schemaOverrides), len(schemaWarnings))
+}
@@ -0,0 +1,179 @@
+package migrator
+
+import (
+	"strings"
+	"testing"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+func TestParseSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		mappings int
+		wantErr  bool
+	}{
+		{"empty file", "", 0, false},
+		{"mappings", "email:\n  kind: account\n  attribute: email\nkey:\n  kind: account\n  attribute: key\n", 2, false},
+		{"unknown field", "email:\n  kin: account\n  attribute: email\n", 0, true},
+		{"not a mapping", "- email\n", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mappings, _, err := parseSchema([]byte(tt.file))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(mappings) != tt.mappings {
+				t.Errorf("parseSchema() = %v, want %v mapping(s)", mappings, tt.mappings)
+			}
+		})
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name         string
+		schema       map[string]attributeSchema
+		wantError    string
+		wantWarnings int
+	}{
+		{"valid", map[string]attributeSchema{"email": {Kind: "account", Attribute: "email"}}, "", 0},
+		{"missing kind", map[string]attributeSchema{"email": {Attribute: "email"}}, "'email' has no kind.", 0},
+		{"missing attribute", map[string]attributeSchema{"email": {Kind: "account"}}, "'email' has no attribute.", 0},
+		{"reserved kind", map[string]attributeSchema{"email": {Kind: "multi", Attribute: "email"}}, "'multi' is a reserved name", 0},
+		{"invalid kind", map[string]attributeSchema{"email": {Kind: "my account", Attribute: "email"}}, "may only contain letters", 0},
+		{"reserved attribute", map[string]attributeSchema{"email": {Kind: "account", Attribute: "kind"}}, "'kind' is reserved", 0},
+		{"unknown type", map[string]attributeSchema{"age": {Kind: "account", Attribute: "age", Type: "integer"}}, "unknown type 'integer'", 0},
+		{"invalid user attribute", map[string]attributeSchema{"/a//b": {Kind: "account", Attribute: "b"}}, "isn't a valid user attribute", 0},
+		{
+			"same user attribute twice",
+			map[string]attributeSchema{"org/id": {Kind: "account", Attribute: "id"}, "/org~1id": {Kind: "account", Attribute: "org"}},
+			"refer to the same user attribute",
+			0,
+		},
+		{
+			"same destination twice",
+			map[string]attributeSchema{"email": {Kind: "account", Attribute: "email"}, "mail": {Kind: "account", Attribute: "/email"}},
+			"",
+			1,
+		},
+		{"key to another attribute", map[string]attributeSchema{keyAttribute: {Kind: "account", Attribute: "id"}}, "", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schemaErrors, warnings := validateSchema(tt.schema, nil)
+			if tt.wantError == "" && len(schemaErrors) > 0 {
+				t.Errorf("validateSchema() errors = %v, want none", schemaErrors)
+			}
+			if tt.wantError != "" && (len(schemaErrors) != 1 || !strings.Contains(schemaErrors[0], tt.wantError)) {
+				t.Errorf("validateSchema() errors = %v, want one containing %q", schemaErrors, tt.wantError)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("validateSchema() warnings = %v, want %v", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestParseSchemaOverrides(t *testing.T) {
+	file := `
+email:
+  kind: member
+  attribute: email
+/overrides:
+  kind: account
+  attribute: overrides
+overrides:
+  - flags: ["legacy-*"]
+    mappings:
+      overrides:
+        kind: device
+        attribute: overrides
+    remove: [email]
+`
+	mappings, overrides, err := parseSchema([]byte(file))
+	if err != nil {
+		t.Fatalf("parseSchema() error = %v", err)
+	}
+	if len(mappings) != 2 || len(overrides) != 1 {
+		t.Fatalf("parseSchema() = %v mapping(s), %v override block(s), want 2 and 1", len(mappings), len(overrides))
+	}
+	if mapping, isMapped := lookupMapping(mappings, "overrides"); !isMapped || mapping.Kind != "account" {
+		t.Errorf("lookupMapping(overrides) = %v, %v, want the account mapping", mapping, isMapped)
+	}
+	if _, isMapped := lookupMapping(overrides[0].Mappings, "overrides"); !isMapped {
+		t.Errorf("the override block doesn't map 'overrides'")
+	}
+}
+
+func TestValidateSchemaOverrides(t *testing.T) {
+	mappings := map[string]attributeSchema{"email": {Kind: "member", Attribute: "email"}}
+
+	tests := []struct {
+		name         string
+		override     schemaOverride
+		wantError    string
+		wantWarnings int
+	}{
+		{"valid", schemaOverride{Flags: []string{"legacy-*"}, Remove: []string{"email"}}, "", 0},
+		{"no criteria", schemaOverride{Remove: []string{"email"}}, "needs at least one of environments or flags", 0},
+		{"invalid flag pattern", schemaOverride{Flags: []string{"legacy-["}, Remove: []string{"email"}}, "the flag pattern 'legacy-[' is invalid", 0},
+		{"nothing to change", schemaOverride{Environments: []string{"production"}}, "", 1},
+		{
+			"invalid mapping",
+			schemaOverride{Environments: []string{"production"}, Mappings: map[string]attributeSchema{"email": {Attribute: "email"}}},
+			"Override 1: 'email' has no kind.",
+			0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schemaErrors, warnings := validateSchema(mappings, []schemaOverride{tt.override})
+			if tt.wantError == "" && len(schemaErrors) > 0 {
+				t.Errorf("validateSchema() errors = %v, want none", schemaErrors)
+			}
+			if tt.wantError != "" && (len(schemaErrors) != 1 || !strings.Contains(schemaErrors[0], tt.wantError)) {
+				t.Errorf("validateSchema() errors = %v, want one containing %q", schemaErrors, tt.wantError)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("validateSchema() warnings = %v, want %v", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestBaseMappingForFlagOverrides(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{"email": {Kind: "member", Attribute: "email"}})
+	savedOverrides, savedEnv := schemaOverrides, envKey
+	t.Cleanup(func() { schemaOverrides, envKey = savedOverrides, savedEnv })
+	envKey = "production"
+	schemaOverrides = []schemaOverride{
+		{Flags: []string{"legacy-*"}, Remove: []string{"email"}},
+		{Environments: []string{"production"}, Flags: []string{"legacy-billing"}, Mappings: map[string]attributeSchema{"email": {Kind: "account", Attribute: "email"}}},
+		{Environments: []string{"staging"}, Mappings: map[string]attributeSchema{"email": {Kind: "device", Attribute: "email"}}},
+	}
+
+	tests := []struct {
+		flagKey   string
+		wantKind  string
+		wantFound bool
+	}{
+		{"checkout", "member", true},
+		{"legacy-search", "", false},
+		{"legacy-billing", "account", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.flagKey, func(t *testing.T) {
+			mapping, isMapped := baseMappingForFlag(ldapi.FeatureFlag{Key: tt.flagKey}, "email")
+			if isMapped != tt.wantFound || mapping.Kind != tt.wantKind {
+				t.Errorf("baseMappingForFlag() = %v, %v, want kind %v, %v", mapping.Kind, isMapped, tt.wantKind, tt.wantFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,400 @@
+package migrator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The first version of each LaunchDarkly SDK package that supports contexts, for each package ecosystem. Packages
+// that were first published after contexts were introduced support them in every version.
+var contextAwareVersions = map[string]map[string]string{
+	"go": {
+		"github.com/launchdarkly/go-server-sdk": "6.0.0",
+	},
+	"npm": {
+		"launchdarkly-node-server-sdk":          "7.0.0",
+		"@launchdarkly/node-server-sdk":         everyVersion,
+		"launchdarkly-js-client-sdk":            "3.0.0",
+		"@launchdarkly/js-client-sdk":           everyVersion,
+		"launchdarkly-node-client-sdk":          "3.0.0",
+		"launchdarkly-react-client-sdk":         "3.0.0",
+		"launchdarkly-react-native-client-sdk":  "7.0.0",
+		"@launchdarkly/react-native-client-sdk": everyVersion,
+		"launchdarkly-vue-client-sdk":           "2.0.0",
+		"@launchdarkly/cloudflare-server-sdk":   everyVersion,
+		"@launchdarkly/vercel-server-sdk":       everyVersion,
+	},
+	"rubygems": {
+		"launchdarkly-server-sdk": "7.0.0",
+	},
+	"pypi": {
+		"launchdarkly-server-sdk": "8.0.0",
+	},
+	"maven": {
+		"com.launchdarkly:launchdarkly-java-server-sdk":    "6.0.0",
+		"com.launchdarkly:launchdarkly-android-client-sdk": "4.0.0",
+	},
+	"nuget": {
+		"LaunchDarkly.ServerSdk": "7.0.0",
+		"LaunchDarkly.ClientSdk": "3.0.0",
+	},
+}
+
+const (
+	sdkReady    = "ready"
+	sdkOutdated = "upgrade needed"
+	sdkUnknown  = "unknown version"
+
+	everyVersion = "0.0.0"
+)
+
+// A LaunchDarkly SDK dependency found in a repository's manifest file
+type sdkDependency struct {
+	file    string
+	name    string
+	version string
+	minimum string
+}
+
+// Helper function to get whether the dependency supports contexts
+func (d sdkDependency) status() string {
+	if d.minimum == everyVersion {
+		return sdkReady
+	}
+	version, ok := parseVersion(d.version)
+	if !ok {
+		return sdkUnknown
+	}
+	minimum, _ := parseVersion(d.minimum)
+	if compareVersions(version, minimum) < 0 {
+		return sdkOutdated
+	}
+	return sdkReady
+}
+
+// Go module paths include the major version from v2 on, as in github.com/launchdarkly/go-server-sdk/v6
+var goSDKModule = regexp.MustCompile(`^(github\.com/launchdarkly/go-server-sdk)(/v\d+)?$|^gopkg\.in/launchdarkly/go-server-sdk\.v\d+$`)
+
+// The numeric components at the start of a version or version range, as in `^7.1.0` or `>=8.0`
+var versionNumber = regexp.MustCompile(`\d+(\.\d+){0,2}`)
+
+// Find the LaunchDarkly SDK dependencies in a repository's manifest files
+func findSDKDependencies(repo localRepository) ([]sdkDependency, error) {
+	dependencies := []sdkDependency{}
+	err := walkRepository(repo, func(relPath string, content []byte) error {
+		var found []sdkDependency
+		var err error
+		switch name := path.Base(relPath); {
+		case name == "go.mod":
+			found = goModDependencies(content)
+		case name == "package.json":
+			found, err = packageJSONDependencies(content)
+		case name == "Gemfile.lock":
+			found = gemfileLockDependencies(content)
+		case name == "requirements.txt":
+			found = requirementsDependencies(content)
+		case name == "pom.xml":
+			found, err = pomDependencies(content)
+		case strings.HasSuffix(name, ".csproj"):
+			found, err = csprojDependencies(content)
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't read '%v': %v", relPath, err)
+		}
+
+		for _, dependency := range found {
+			dependency.file = relPath
+			dependencies = append(dependencies, dependency)
+		}
+		return nil
+	})
+	return dependencies, err
+}
+
+// Helper function to get a dependency on a known SDK package, or false if the package isn't an SDK
+func sdkPackage(ecosystem string, name string, version string) (sdkDependency, bool) {
+	minimum, isSDK := contextAwareVersions[ecosystem][name]
+	return sdkDependency{name: name, version: version, minimum: minimum}, isSDK
+}
+
+// Only require directives list dependencies. Replace and exclude directives also name module versions, either on one
+// line or in a block such as `replace (`.
+func goModDependencies(content []byte) []sdkDependency {
+	dependencies := []sdkDependency{}
+	block := ""
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.SplitN(scanner.Text(), "//", 2)[0])
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == "(" {
+			block = fields[0]
+			continue
+		} else if line == ")" {
+			block = ""
+			continue
+		}
+
+		if block == "" && len(fields) > 0 && fields[0] == "require" {
+			fields = fields[1:]
+		} else if block != "require" {
+			continue
+		}
+		if len(fields) < 2 || !goSDKModule.MatchString(fields[0]) {
+			continue
+		}
+		if dependency, ok := sdkPackage("go", "github.com/launchdarkly/go-server-sdk", strings.TrimPrefix(fields[1], "v")); ok {
+			dependencies = append(dependencies, dependency)
+		}
+	}
+	return dependencies
+}
+
+func packageJSONDependencies(content []byte) ([]sdkDependency, error) {
+	var manifest struct {
+		Dependencies         map[string]string
+		DevDependencies      map[string]string
+		PeerDependencies     map[string]string
+		OptionalDependencies map[string]string
+	}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, err
+	}
+
+	dependencies := []sdkDependency{}
+	for _, section := range []map[string]string{manifest.Dependencies, manifest.DevDependencies, manifest.PeerDependencies, manifest.OptionalDependencies} {
+		for _, name := range sortedKeys(section) {
+			if dependency, ok := sdkPackage("npm", name, section[name]); ok {
+				dependencies = append(dependencies, dependency)
+			}
+		}
+	}
+	return dependencies, nil
+}
+
+// Gemfile.lock lists each resolved gem as `    name (version)` under its `specs:` sections
+var gemSpec = regexp.MustCompile(`^    ([^ ]+) \(([^)]+)\)$`)
+
+func gemfileLockDependencies(content []byte) []sdkDependency {
+	dependencies := []sdkDependency{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if match := gemSpec.FindStringSubmatch(scanner.Text()); match != nil {
+			if dependency, ok := sdkPackage("rubygems", match[1], match[2]); ok {
+				dependencies = append(dependencies, dependency)
+			}
+		}
+	}
+	return dependencies
+}
+
+// A requirement is a package name with optional extras, followed by an optional version specifier
+var requirement = regexp.MustCompile(`^([A-Za-z0-9._-]+)(\[[^\]]*\])?\s*(.*)$`)
+
+func requirementsDependencies(content []byte) []sdkDependency {
+	dependencies := []sdkDependency{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.SplitN(scanner.Text(), "#", 2)[0])
+		if match := requirement.FindStringSubmatch(line); match != nil {
+			// Package names aren't case sensitive, and '_' and '-' are equivalent
+			name := strings.ReplaceAll(strings.ToLower(match[1]), "_", "-")
+			if dependency, ok := sdkPackage("pypi", name, strings.TrimSpace(strings.SplitN(match[3], ";", 2)[0])); ok {
+				dependencies = append(dependencies, dependency)
+			}
+		}
+	}
+	return dependencies
+}
+
+func pomDependencies(content []byte) ([]sdkDependency, error) {
+	var pom struct {
+		Properties struct {
+			Values []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:"properties"`
+		Dependencies []struct {
+			GroupId    string `xml:"groupId"`
+			ArtifactId string `xml:"artifactId"`
+			Version    string `xml:"version"`
+		} `xml:"dependencies>dependency"`
+		ManagedDependencies []struct {
+			GroupId    string `xml:"groupId"`
+			ArtifactId string `xml:"artifactId"`
+			Version    string `xml:"version"`
+		} `xml:"dependencyManagement>dependencies>dependency"`
+	}
+	if err := xml.Unmarshal(content, &pom); err != nil {
+		return nil, err
+	}
+
+	// Versions are often set by properties, as in <version>${launchdarkly.version}</version>
+	properties := map[string]string{}
+	for _, property := range pom.Properties.Values {
+		properties[property.XMLName.Local] = strings.TrimSpace(property.Value)
+	}
+
+	dependencies := []sdkDependency{}
+	for _, dependency := range append(pom.Dependencies, pom.ManagedDependencies...) {
+		version := strings.TrimSpace(dependency.Version)
+		if strings.HasPrefix(version, "${") && strings.HasSuffix(version, "}") {
+			version = properties[version[2:len(version)-1]]
+		}
+		if found, ok := sdkPackage("maven", strings.TrimSpace(dependency.GroupId)+":"+strings.TrimSpace(dependency.ArtifactId), version); ok {
+			dependencies = append(dependencies, found)
+		}
+	}
+	return dependencies, nil
+}
+
+func csprojDependencies(content []byte) ([]sdkDependency, error) {
+	var project struct {
+		References []struct {
+			Include        string `xml:"Include,attr"`
+			Version        string `xml:"Version,attr"`
+			VersionElement string `xml:"Version"`
+		} `xml:"ItemGroup>PackageReference"`
+	}
+	if err := xml.Unmarshal(content, &project); err != nil {
+		return nil, err
+	}
+
+	dependencies := []sdkDependency{}
+	for _, reference := range project.References {
+		version := reference.Version
+		if version == "" {
+			version = strings.TrimSpace(reference.VersionElement)
+		}
+		if dependency, ok := sdkPackage("nuget", reference.Include, version); ok {
+			dependencies = append(dependencies, dependency)
+		}
+	}
+	return dependencies, nil
+}
+
+// Parse the version that a version or version range starts from. Ranges such as `^7.1.0` or `>=8.0` are compared by
+// their lowest version, and anything without a number, such as `latest`, can't be compared.
+func parseVersion(version string) ([3]int, bool) {
+	parsed := [3]int{}
+	match := versionNumber.FindString(version)
+	if match == "" {
+		return parsed, false
+	}
+	for i, component := range strings.Split(match, ".") {
+		parsed[i], _ = strconv.Atoi(component)
+	}
+	return parsed, true
+}
+
+func compareVersions(a [3]int, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return 0
+}
+
+// Helper function to get the keys of a map in a stable order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Helper function to get whether a repository is ready for the migration, which is when every LaunchDarkly SDK it
+// depends on supports contexts. Repositories without an SDK are skipped rather than ready.
+func isRepositoryReady(dependencies []sdkDependency) bool {
+	for _, dependency := range dependencies {
+		if dependency.status() != sdkReady {
+			return false
+		}
+	}
+	return true
+}
+
+// The local repositories that don't depend on a LaunchDarkly SDK. They don't evaluate flags, so when REPOSITORIES is
+// auto, the flag keys found in them are ignored.
+var reposWithoutSDK []string
+
+// Helper function to get the names of the local repositories that are ready for the migration
+func readyRepositories() []string {
+	ready := []string{}
+	for _, repo := range localRepos {
+		dependencies, err := findSDKDependencies(repo)
+		if err != nil {
+			fmt.Printf("Local repository '%v' isn't ready because it couldn't be scanned: %v.\n", repo.name, err)
+		} else if len(dependencies) == 0 {
+			reposWithoutSDK = append(reposWithoutSDK, repo.name)
+			fmt.Printf("Local repository '%v' is skipped because it doesn't depend on a LaunchDarkly SDK.\n", repo.name)
+		} else if isRepositoryReady(dependencies) {
+			ready = append(ready, repo.name)
+		}
+	}
+	return ready
+}
+
+// SDKReport lists the LaunchDarkly SDKs that each local repository depends on, and whether they support contexts
+func SDKReport() {
+	Configure()
+	requireLocalRepositories()
+
+	fmt.Println("LaunchDarkly SDKs in local repositories:")
+
+	ready := []string{}
+	skipped := 0
+	for _, repo := range localRepos {
+		fmt.Println()
+		dependencies, err := findSDKDependencies(repo)
+		if err != nil {
+			fmt.Printf("Repository '%v' couldn't be scanned: %v\n", repo.name, err)
+			continue
+		}
+
+		if len(dependencies) == 0 {
+			skipped++
+			fmt.Printf("Repository '%v' is skipped (%v)\n", repo.name, repo.path)
+		} else if isRepositoryReady(dependencies) {
+			ready = append(ready, repo.name)
+			fmt.Printf("Repository '%v' is ready (%v)\n", repo.name, repo.path)
+		} else {
+			fmt.Printf("Repository '%v' isn't ready (%v)\n", repo.name, repo.path)
+		}
+		if len(dependencies) == 0 {
+			fmt.Println("  No LaunchDarkly SDKs were found.")
+		}
+		for _, dependency := range dependencies {
+			version := dependency.version
+			if version == "" {
+				version = "no version"
+			}
+			supported := "contexts from " + dependency.minimum
+			if dependency.minimum == everyVersion {
+				supported = "contexts in every version"
+			}
+			fmt.Printf("  %v: %v %v (%v): %v\n", dependency.file, dependency.name, version, supported, dependency.status())
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("%v of %v repository(ies) with LaunchDarkly SDKs are ready", len(ready), len(localRepos)-skipped)
+	if len(ready) > 0 {
+		fmt.Printf(": %v", strings.Join(ready, ", "))
+	}
+	fmt.Println(".")
+	if skipped > 0 {
+		fmt.Printf("%v repository(ies) were skipped because they don't depend on a LaunchDarkly SDK.\n", skipped)
+	}
+}
@@ -0,0 +1,71 @@
+package migrator
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGoModDependencies(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		versions []string
+	}{
+		{"single require", "module web\n\nrequire github.com/launchdarkly/go-server-sdk/v6 v6.1.0\n", []string{"6.1.0"}},
+		{"require block", "require (\n\tgithub.com/launchdarkly/go-server-sdk/v5 v5.10.1 // indirect\n\tgolang.org/x/text v0.3.0\n)\n", []string{"5.10.1"}},
+		{"gopkg.in path", "require gopkg.in/launchdarkly/go-server-sdk.v4 v4.17.3\n", []string{"4.17.3"}},
+		{"single replace", "require github.com/launchdarkly/go-server-sdk/v6 v6.1.0\nreplace github.com/launchdarkly/go-server-sdk/v6 v6.1.0 => ../go-server-sdk\n", []string{"6.1.0"}},
+		{
+			"replace block",
+			"require github.com/launchdarkly/go-server-sdk/v6 v6.1.0\n\nreplace (\n\tgithub.com/launchdarkly/go-server-sdk/v5 v5.0.0 => ../fork\n)\n",
+			[]string{"6.1.0"},
+		},
+		{"exclude block", "exclude (\n\tgithub.com/launchdarkly/go-server-sdk/v5 v5.0.0\n)\n", []string{}},
+		{"other modules", "require github.com/launchdarkly/go-sdk-common/v3 v3.0.0\n", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			versions := []string{}
+			for _, dependency := range goModDependencies([]byte(tt.content)) {
+				versions = append(versions, dependency.version)
+			}
+			if !reflect.DeepEqual(versions, tt.versions) {
+				t.Errorf("goModDependencies() versions = %v, want %v", versions, tt.versions)
+			}
+		})
+	}
+}
+
+func TestReadyRepositories(t *testing.T) {
+	savedLocalRepos, savedWithoutSDK := localRepos, reposWithoutSDK
+	t.Cleanup(func() { localRepos, reposWithoutSDK = savedLocalRepos, savedWithoutSDK })
+
+	manifests := map[string]string{
+		"ready":    "require github.com/launchdarkly/go-server-sdk/v6 v6.1.0\n",
+		"outdated": "require github.com/launchdarkly/go-server-sdk/v5 v5.10.1\n",
+		"docs":     "module docs\n",
+	}
+	root := t.TempDir()
+	localRepos = nil
+	reposWithoutSDK = nil
+	for _, name := range []string{"docs", "outdated", "ready"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "go.mod"), []byte(manifests[name]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		localRepos = append(localRepos, localRepository{name, path})
+	}
+
+	if got := readyRepositories(); !reflect.DeepEqual(got, []string{"ready"}) {
+		t.Errorf("readyRepositories() = %v, want [ready]", got)
+	}
+	if !reflect.DeepEqual(reposWithoutSDK, []string{"docs"}) {
+		t.Errorf("reposWithoutSDK = %v, want [docs]", reposWithoutSDK)
+	}
+}
@@ -0,0 +1,194 @@
+package migrator
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// At least this share of an attribute's flag values must be seen in a context attribute for a high confidence match
+const highConfidenceOverlap = 0.5
+
+// A context kind and attribute that a user attribute may map to
+type suggestion struct {
+	kind      string
+	attribute string
+	matched   int
+	nameMatch bool
+	count     int
+}
+
+// SuggestSchema proposes a draft schema by comparing the user attributes referenced by the project's flags with the
+// kinds and attributes of sample contexts. Each proposed mapping has a note on how confident the match is.
+func SuggestSchema() {
+	Configure()
+	requireAPIKey()
+
+	contexts, err := loadSampleContexts()
+	if err != nil {
+		log.Fatalf("Failed to load sample contexts: %v.", err)
+		os.Exit(10)
+	}
+	observed := observeContexts(contexts)
+	if observed.total == 0 {
+		log.Fatal("No sample contexts were found to compare the flags against.")
+		os.Exit(10)
+	}
+
+	fmt.Printf("Sampled %v context(s) with these kinds:\n", observed.total)
+	for _, kind := range observed.sortedKinds() {
+		fmt.Printf("  %v: %v context(s), %v attribute(s)\n", kind, observed.kinds[kind], len(observed.attributes[kind]))
+	}
+	fmt.Println()
+
+	usage := map[string]*attributeUsage{}
+	collectAttributeUsage(getFlags(envKey).Items, envKey, usage)
+	fmt.Printf("Found %v user attribute(s) referenced by flags in project '%v' and environment '%v'.\n", len(usage), projectKey, envKey)
+	fmt.Println()
+
+	fmt.Println("Review this draft schema before using it. Mappings without a note of high confidence need a closer look:")
+	fmt.Println()
+	for _, attribute := range sortedUsage(usage) {
+		fmt.Print(suggestMapping(usage[attribute], observed))
+	}
+}
+
+// Build the schema snippet for one user attribute
+func suggestMapping(u *attributeUsage, observed observedContexts) string {
+	name := yamlScalar(u.attribute)
+
+	if mapping, isMapped := lookupMapping(schema, u.attribute); isMapped {
+		return fmt.Sprintf("# Kept from the schema file.\n%v:\n  kind: %v\n  attribute: %v\n", name, yamlScalar(mapping.Kind), yamlScalar(mapping.Attribute))
+	}
+
+	best, alternatives := rankSuggestions(u, observed)
+	if best == nil {
+		note := fmt.Sprintf("No context attribute in the sample matches this user attribute by name or by %v value(s) used in flags.", len(u.values))
+		if _, builtinNote, isBuiltin := legacyBuiltin(u.attribute); isBuiltin {
+			note += " " + builtinNote
+		}
+		return fmt.Sprintf("# %v\n# %v:\n#   kind: ?\n#   attribute: ?\n", note, name)
+	}
+
+	notes := []string{confidenceNote(u, *best)}
+	for _, alternative := range alternatives {
+		notes = append(notes, fmt.Sprintf("Also matches %v of the values in '%v' attribute '%v'.", alternative.matched, alternative.kind, alternative.attribute))
+	}
+	snippet := ""
+	for _, note := range notes {
+		snippet += "# " + note + "\n"
+	}
+
+	if best.kind == userKind && sameAttribute(best.attribute, u.attribute) {
+		// The attribute already exists on the user kind, so it doesn't need a mapping
+		return snippet + fmt.Sprintf("# %v:\n#   kind: %v\n#   attribute: %v\n", name, userKind, name)
+	}
+	return snippet + fmt.Sprintf("%v:\n  kind: %v\n  attribute: %v\n", name, yamlScalar(best.kind), yamlScalar(best.attribute))
+}
+
+// Score every observed kind and attribute against a user attribute. Candidates must share at least one value with
+// the flags or have a matching name. The best candidate is returned along with up to two runners-up that share values.
+func rankSuggestions(u *attributeUsage, observed observedContexts) (*suggestion, []suggestion) {
+	candidates := []suggestion{}
+	for _, kind := range observed.sortedKinds() {
+		for attribute, o := range observed.attributes[kind] {
+			candidate := suggestion{kind: kind, attribute: displayAttribute(attribute), count: o.count}
+			for _, value := range u.values {
+				if o.values[fmt.Sprint(value)] {
+					candidate.matched++
+				}
+			}
+			candidate.nameMatch = namesMatch(u.attribute, kind, attribute)
+			if candidate.matched > 0 || candidate.nameMatch {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.matched != b.matched {
+			return a.matched > b.matched
+		}
+		if a.nameMatch != b.nameMatch {
+			return a.nameMatch
+		}
+		if a.count != b.count {
+			return a.count > b.count
+		}
+		return a.kind+a.attribute < b.kind+b.attribute
+	})
+
+	alternatives := []suggestion{}
+	for _, candidate := range candidates[1:] {
+		if candidate.matched > 0 && len(alternatives) < 2 {
+			alternatives = append(alternatives, candidate)
+		}
+	}
+	return &candidates[0], alternatives
+}
+
+// Helper function to describe how confident a suggestion is
+func confidenceNote(u *attributeUsage, s suggestion) string {
+	target := fmt.Sprintf("'%v' attribute '%v'", s.kind, s.attribute)
+
+	if len(u.values) == 0 {
+		return fmt.Sprintf("Medium confidence: the name matches %v. The flags use no values to compare.", target)
+	}
+
+	share := float64(s.matched) / float64(len(u.values))
+	switch {
+	case share >= highConfidenceOverlap:
+		return fmt.Sprintf("High confidence: %v of %v value(s) used in flags were seen in %v.", s.matched, len(u.values), target)
+	case s.matched > 0:
+		return fmt.Sprintf("Medium confidence: %v of %v value(s) used in flags were seen in %v.", s.matched, len(u.values), target)
+	default:
+		return fmt.Sprintf("Low confidence: the name matches %v, but none of the %v value(s) used in flags were seen in it.", target, len(u.values))
+	}
+}
+
+// Returns true if a user attribute's name suggests a context attribute, such as `zipCode` for 'user' attribute
+// `zipCode`, `accountName` for 'account' attribute `name`, or `accountId` and `device` for the keys of 'account' and
+// 'device'
+func namesMatch(userAttribute string, kind string, attribute string) bool {
+	normalize := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r == '/' || r == '_' || r == '-' || r == '.' || r == '~' {
+				return -1
+			}
+			return r
+		}, strings.ToLower(s))
+	}
+	u := normalize(canonicalAttribute(userAttribute))
+	k := normalize(kind)
+	a := normalize(attribute)
+
+	if u == a || u == k+a {
+		return true
+	}
+	return a == keyAttribute && (u == k || u == k+"id" || u == k+"key")
+}
+
+// Helper function to write a canonical attribute reference as a literal name when it has a single component
+func displayAttribute(attribute string) string {
+	if components, err := parseAttributeRef(attribute); err == nil && len(components) == 1 {
+		return components[0]
+	}
+	return attribute
+}
+
+// Helper function to write a string as a YAML scalar, quoting it where needed
+func yamlScalar(s string) string {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Sprintf("%q", s)
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
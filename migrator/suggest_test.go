@@ -0,0 +1,74 @@
+package migrator
+
+import (
+	"testing"
+)
+
+func TestNamesMatch(t *testing.T) {
+	tests := []struct {
+		userAttribute string
+		kind          string
+		attribute     string
+		want          bool
+	}{
+		{"zipCode", "user", "/zipCode", true},
+		{"zip_code", "user", "/zipCode", true},
+		{"accountName", "account", "/name", true},
+		{"accountId", "account", "/key", true},
+		{"account-key", "account", "/key", true},
+		{"device", "device", "/key", true},
+		{"/address/zip", "location", "/address/zip", true},
+		{"accountId", "account", "/name", false},
+		{"email", "account", "/name", false},
+		{"device", "account", "/key", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.userAttribute+" "+tt.kind+tt.attribute, func(t *testing.T) {
+			if got := namesMatch(tt.userAttribute, tt.kind, tt.attribute); got != tt.want {
+				t.Errorf("namesMatch(%v, %v, %v) = %v, want %v", tt.userAttribute, tt.kind, tt.attribute, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankSuggestions(t *testing.T) {
+	observed := observeContexts([]map[string]interface{}{
+		{"kind": "account", "key": "a-1", "name": "Acme", "orgId": "org-1"},
+		{"kind": "account", "key": "a-2", "name": "Initech", "orgId": "org-2"},
+		{"kind": "organization", "key": "org-1", "accountId": "a-1"},
+		{"kind": "multi", "device": map[string]interface{}{"key": "d-1", "os": "ios"}},
+	})
+
+	tests := []struct {
+		name             string
+		usage            attributeUsage
+		wantKind         string
+		wantAttribute    string
+		wantAlternatives int
+	}{
+		{"no candidates", attributeUsage{attribute: "plan", values: []interface{}{"pro"}}, "", "", 0},
+		{"name only", attributeUsage{attribute: "os"}, "device", "os", 0},
+		{"values beat the name", attributeUsage{attribute: "accountId", values: []interface{}{"a-1", "a-2"}}, "account", "key", 1},
+		{"name breaks a tie in values", attributeUsage{attribute: "orgId", values: []interface{}{"org-1"}}, "account", "orgId", 1},
+		{"more values matched", attributeUsage{attribute: "company", values: []interface{}{"org-1", "org-2"}}, "account", "orgId", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			best, alternatives := rankSuggestions(&tt.usage, observed)
+			if tt.wantKind == "" {
+				if best != nil {
+					t.Errorf("rankSuggestions() = %+v, want no suggestion", *best)
+				}
+				return
+			}
+			if best == nil || best.kind != tt.wantKind || best.attribute != tt.wantAttribute {
+				t.Fatalf("rankSuggestions() = %+v, want '%v' attribute '%v'", best, tt.wantKind, tt.wantAttribute)
+			}
+			if len(alternatives) != tt.wantAlternatives {
+				t.Errorf("rankSuggestions() alternatives = %+v, want %v", alternatives, tt.wantAlternatives)
+			}
+		})
+	}
+}
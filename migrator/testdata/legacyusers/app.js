@@ -0,0 +1,29 @@
+const LaunchDarkly = require('launchdarkly-node-server-sdk');
+
+const client = LaunchDarkly.init(process.env.LD_SDK_KEY);
+
+// Legacy user passed directly
+client.variation('new-checkout', { key: 'user-1', email: 'a@example.com' }, false);
+
+// Legacy user built elsewhere, with the key after other properties
+const currentUser = {
+  name: 'Ada',
+  email: 'ada@example.com',
+  key: 'user-2',
+};
+client.identify(currentUser);
+
+// Legacy user in a variable that isn't named like a user
+const requester = { anonymous: true, key: 'user-3' };
+client.variation('new-search', requester, false);
+
+// Contexts aren't legacy users
+const context = { kind: 'organization', key: 'org-1' };
+const orgUser = {
+  kind: 'user',
+  key: 'user-4',
+};
+
+// Objects that aren't users
+const settings = { key: 'theme', value: 'dark' };
+const userPrefs = { theme: 'dark', custom: { key: 'nested' } };
@@ -0,0 +1,13 @@
+import ldclient
+
+# Legacy user passed directly
+ldclient.get().variation("new-checkout", {"key": "user-1"}, False)
+
+# Legacy user built elsewhere
+ld_user = {
+    "email": "a@example.com",
+    "key": "user-2",
+}
+
+# A context
+context = {"kind": "user", "key": "user-3"}
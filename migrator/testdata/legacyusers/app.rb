@@ -0,0 +1,11 @@
+client = LaunchDarkly::LDClient.new(ENV["LD_SDK_KEY"])
+
+# Legacy user built elsewhere, with a symbol key
+user = { :name => "Ada", :key => "user-1" }
+client.variation("new-checkout", user, false)
+
+# Legacy user passed directly
+client.variation("new-search", { email: "a@example.com", key: "user-2" }, false)
+
+# A context
+client.variation("new-search", { kind: "user", key: "user-3" }, false)
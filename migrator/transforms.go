@@ -0,0 +1,242 @@
+package migrator
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A single step that changes attribute values as they move from the user schema to the context schema. Exactly one
+// of the fields must be set.
+type valueTransform struct {
+	StripPrefix string                 `yaml:"stripPrefix"`
+	StripSuffix string                 `yaml:"stripSuffix"`
+	AddPrefix   string                 `yaml:"addPrefix"`
+	AddSuffix   string                 `yaml:"addSuffix"`
+	Replace     *regexReplace          `yaml:"replace"`
+	Case        string                 `yaml:"case"`
+	Type        string                 `yaml:"type"`
+	Lookup      map[string]interface{} `yaml:"lookup"`
+}
+
+type regexReplace struct {
+	Pattern string
+	With    string
+	regex   *regexp.Regexp
+}
+
+const (
+	caseLower   = "lower"
+	caseUpper   = "upper"
+	typeString  = "string"
+	typeNumber  = "number"
+	typeBoolean = "boolean"
+)
+
+// Operators whose values aren't attribute values, so transforming them would change their meaning
+var opsWithoutTransforms = []string{
+	"matches", // Values are regular expressions
+}
+
+// Check that each transform sets exactly one step and that the step is well-formed. Regular expressions are compiled
+// here so that they're ready when the transforms are applied.
+func validateTransforms(transforms []valueTransform) error {
+	for i := range transforms {
+		transform := &transforms[i]
+
+		steps := 0
+		for _, isSet := range []bool{
+			transform.StripPrefix != "",
+			transform.StripSuffix != "",
+			transform.AddPrefix != "",
+			transform.AddSuffix != "",
+			transform.Replace != nil,
+			transform.Case != "",
+			transform.Type != "",
+			transform.Lookup != nil,
+		} {
+			if isSet {
+				steps++
+			}
+		}
+		if steps != 1 {
+			return fmt.Errorf("transform %v must have exactly one step, but has %v", i+1, steps)
+		}
+
+		if transform.Case != "" && transform.Case != caseLower && transform.Case != caseUpper {
+			return fmt.Errorf("transform %v has an unknown case '%v'", i+1, transform.Case)
+		}
+		if transform.Type != "" && transform.Type != typeString && transform.Type != typeNumber && transform.Type != typeBoolean {
+			return fmt.Errorf("transform %v has an unknown type '%v'", i+1, transform.Type)
+		}
+		if transform.Replace != nil {
+			regex, err := regexp.Compile(transform.Replace.Pattern)
+			if err != nil {
+				return fmt.Errorf("transform %v has an invalid pattern: %v", i+1, err)
+			}
+			transform.Replace.regex = regex
+		}
+	}
+
+	return nil
+}
+
+// Apply a mapping's transforms to a list of clause values. Values that can't be transformed are kept as they are.
+func transformValues(userAttribute string, op string, values []interface{}, mapping attributeSchema) []interface{} {
+	transformed, failures := applyTransforms(userAttribute, op, values, mapping)
+	for _, failure := range failures {
+		fmt.Printf("  Warning: %v. The value is kept as it is.\n", failure)
+	}
+	return transformed
+}
+
+// Helper function to apply a mapping's transforms to a list of clause values without reporting anything, so that
+// the guardrails check exactly the values that the migration writes. Values that can't be transformed are kept as
+// they are, and an error is returned for each of them.
+func applyTransforms(userAttribute string, op string, values []interface{}, mapping attributeSchema) ([]interface{}, []error) {
+	if len(mapping.Transforms) == 0 || contains(opsWithoutTransforms, op) {
+		return values, nil
+	}
+
+	transformed := make([]interface{}, 0, len(values))
+	failures := []error{}
+	for _, value := range values {
+		newValue, err := transformValue(value, mapping.Transforms)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("couldn't transform value '%v' for user attribute '%v' because %v", value, userAttribute, err))
+			newValue = value
+		}
+		transformed = append(transformed, newValue)
+	}
+
+	return transformed, failures
+}
+
+// Apply a mapping's transforms to a list of context keys. Keys are always strings.
+func transformKeys(userAttribute string, keys []string, mapping attributeSchema) []string {
+	if len(mapping.Transforms) == 0 {
+		return keys
+	}
+
+	transformed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		newKey, err := transformValue(key, mapping.Transforms)
+		if err != nil {
+			fmt.Printf("  Warning: couldn't transform value '%v' for user attribute '%v' because %v. The value is kept as it is.\n", key, userAttribute, err)
+			newKey = key
+		}
+		str, _ := toType(newKey, typeString)
+		transformed = append(transformed, str.(string))
+	}
+
+	return transformed
+}
+
+// Coerce transformed values to the type that the schema declares for their destination, where that type is one the
+// values can be coerced to. Values that can't be coerced are kept as they are, so that the type check reports them.
+func toDeclaredType(values []interface{}, mapping attributeSchema) []interface{} {
+	if mapping.Type != typeString && mapping.Type != typeNumber && mapping.Type != typeBoolean {
+		return values
+	}
+
+	coerced := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		if newValue, err := toType(value, mapping.Type); err == nil {
+			value = newValue
+		}
+		coerced = append(coerced, value)
+	}
+	return coerced
+}
+
+func transformValue(value interface{}, transforms []valueTransform) (interface{}, error) {
+	var err error
+
+	for _, transform := range transforms {
+		if transform.Type != "" {
+			value, err = toType(value, transform.Type)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if transform.Lookup != nil {
+			str, _ := toType(value, typeString)
+			if replacement, found := transform.Lookup[str.(string)]; found {
+				value = replacement
+			}
+			continue
+		}
+
+		// The remaining steps only apply to strings
+		str, isStr := value.(string)
+		if !isStr {
+			return nil, fmt.Errorf("it isn't a string (add a `type: string` transform first)")
+		}
+
+		switch {
+		case transform.StripPrefix != "":
+			str = strings.TrimPrefix(str, transform.StripPrefix)
+		case transform.StripSuffix != "":
+			str = strings.TrimSuffix(str, transform.StripSuffix)
+		case transform.AddPrefix != "":
+			str = transform.AddPrefix + str
+		case transform.AddSuffix != "":
+			str = str + transform.AddSuffix
+		case transform.Replace != nil:
+			str = transform.Replace.regex.ReplaceAllString(str, transform.Replace.With)
+		case transform.Case == caseLower:
+			str = strings.ToLower(str)
+		case transform.Case == caseUpper:
+			str = strings.ToUpper(str)
+		}
+		value = str
+	}
+
+	return value, nil
+}
+
+// Coerce a JSON value to a string, number or boolean
+func toType(value interface{}, valueType string) (interface{}, error) {
+	switch valueType {
+	case typeString:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		default:
+			return fmt.Sprint(v), nil
+		}
+	case typeNumber:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		case string:
+			number, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("it isn't a number")
+			}
+			return number, nil
+		}
+		return nil, fmt.Errorf("it isn't a number")
+	case typeBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			boolean, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("it isn't a boolean")
+			}
+			return boolean, nil
+		}
+		return nil, fmt.Errorf("it isn't a boolean")
+	}
+
+	return value, nil
+}
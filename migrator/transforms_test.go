@@ -0,0 +1,126 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTransformValue(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      interface{}
+		transforms []valueTransform
+		want       interface{}
+		wantErr    bool
+	}{
+		{"strip prefix", "acct-123", []valueTransform{{StripPrefix: "acct-"}}, "123", false},
+		{"strip missing prefix", "123", []valueTransform{{StripPrefix: "acct-"}}, "123", false},
+		{"strip suffix", "team@example.com", []valueTransform{{StripSuffix: "@example.com"}}, "team", false},
+		{"add prefix and suffix", "123", []valueTransform{{AddPrefix: "org-"}, {AddSuffix: "-eu"}}, "org-123-eu", false},
+		{"replace", "a-b-c", []valueTransform{{Replace: &regexReplace{Pattern: "-", With: "_"}}}, "a_b_c", false},
+		{"lower case", "MiXeD", []valueTransform{{Case: caseLower}}, "mixed", false},
+		{"upper case", "MiXeD", []valueTransform{{Case: caseUpper}}, "MIXED", false},
+		{"number to string", float64(94107), []valueTransform{{Type: typeString}}, "94107", false},
+		{"string to number", "94107", []valueTransform{{Type: typeNumber}}, float64(94107), false},
+		{"string to boolean", "true", []valueTransform{{Type: typeBoolean}}, true, false},
+		{"invalid number", "zip", []valueTransform{{Type: typeNumber}}, nil, true},
+		{"lookup", "gold", []valueTransform{{Lookup: map[string]interface{}{"gold": "premium"}}}, "premium", false},
+		{"lookup miss", "silver", []valueTransform{{Lookup: map[string]interface{}{"gold": "premium"}}}, "silver", false},
+		{"string step on a number", float64(1), []valueTransform{{StripPrefix: "a"}}, nil, true},
+		{"coerce then strip", float64(10), []valueTransform{{Type: typeString}, {AddPrefix: "v"}}, "v10", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateTransforms(tt.transforms); err != nil {
+				t.Fatalf("validateTransforms() error = %v", err)
+			}
+			got, err := transformValue(tt.value, tt.transforms)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("transformValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("transformValue() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTransforms(t *testing.T) {
+	tests := []struct {
+		name       string
+		transforms []valueTransform
+		wantErr    bool
+	}{
+		{"one step", []valueTransform{{StripPrefix: "a"}}, false},
+		{"no steps", []valueTransform{{}}, true},
+		{"two steps", []valueTransform{{StripPrefix: "a", AddSuffix: "b"}}, true},
+		{"unknown case", []valueTransform{{Case: "title"}}, true},
+		{"unknown type", []valueTransform{{Type: "date"}}, true},
+		{"invalid pattern", []valueTransform{{Replace: &regexReplace{Pattern: "("}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateTransforms(tt.transforms); (err != nil) != tt.wantErr {
+				t.Errorf("validateTransforms() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransformValues(t *testing.T) {
+	mapping := attributeSchema{Kind: "account", Attribute: "key", Transforms: []valueTransform{{StripPrefix: "acct-"}}}
+
+	tests := []struct {
+		name   string
+		op     string
+		values []interface{}
+		want   []interface{}
+	}{
+		{"in", "in", []interface{}{"acct-1", "acct-2"}, []interface{}{"1", "2"}},
+		{"matches keeps regular expressions", "matches", []interface{}{"^acct-"}, []interface{}{"^acct-"}},
+		{"failures keep the value", "in", []interface{}{float64(3)}, []interface{}{float64(3)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transformValues("accountId", tt.op, tt.values, mapping); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("transformValues() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformKeys(t *testing.T) {
+	mapping := attributeSchema{Kind: "account", Attribute: "key", Transforms: []valueTransform{{Type: typeNumber}, {Type: typeString}}}
+	got := transformKeys(keyAttribute, []string{"007", "abc"}, mapping)
+	want := []string{"7", "abc"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("transformKeys() = %#v, want %#v", got, want)
+	}
+}
+
+func TestToDeclaredType(t *testing.T) {
+	tests := []struct {
+		name     string
+		declared string
+		values   []interface{}
+		want     []interface{}
+	}{
+		{"number", typeNumber, []interface{}{"94107", "zip"}, []interface{}{float64(94107), "zip"}},
+		{"string", typeString, []interface{}{float64(5)}, []interface{}{"5"}},
+		{"boolean", typeBoolean, []interface{}{"false"}, []interface{}{false}},
+		{"undeclared", "", []interface{}{"1"}, []interface{}{"1"}},
+		{"semver isn't coerced", typeSemver, []interface{}{"1.0"}, []interface{}{"1.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping := attributeSchema{Kind: "location", Attribute: "zip", Type: tt.declared}
+			if got := toDeclaredType(tt.values, mapping); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toDeclaredType() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package migrator
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+// Types that the schema can declare for a destination attribute, in addition to the transform types
+const (
+	typeSemver = "semver"
+	typeDate   = "date"
+)
+
+var attributeTypes = []string{typeString, typeNumber, typeBoolean, typeSemver, typeDate}
+
+// The attribute types that each operator can compare. Operators that aren't listed aren't checked.
+var operatorTypes = map[string][]string{
+	"in":                 attributeTypes,
+	"startsWith":         {typeString},
+	"endsWith":           {typeString},
+	"contains":           {typeString},
+	"matches":            {typeString},
+	"lessThan":           {typeNumber},
+	"lessThanOrEqual":    {typeNumber},
+	"greaterThan":        {typeNumber},
+	"greaterThanOrEqual": {typeNumber},
+	"before":             {typeDate},
+	"after":              {typeDate},
+	"semVerEqual":        {typeSemver},
+	"semVerLessThan":     {typeSemver},
+	"semVerGreaterThan":  {typeSemver},
+}
+
+// Semantic versions may leave out the minor and patch versions, as in `2` or `2.1`
+var semverValue = regexp.MustCompile(`^\d+(\.\d+){0,2}(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+func validateAttributeType(attributeType string) error {
+	if attributeType != "" && !contains(attributeTypes, attributeType) {
+		return fmt.Errorf("has an unknown type '%v'", attributeType)
+	}
+	return nil
+}
+
+// Check a migrated clause's operator and values against the type that the schema declares for its destination.
+// Returns nothing when no type is declared.
+func clauseTypeProblems(op string, values []interface{}, mapping attributeSchema) []string {
+	problems := []string{}
+	if mapping.Type == "" {
+		return problems
+	}
+
+	types, isKnown := operatorTypes[op]
+	if !isKnown {
+		return problems
+	}
+	if !contains(types, mapping.Type) {
+		return append(problems, fmt.Sprintf("operator '%v' can't compare '%v' attribute '%v' of type '%v'", op, mapping.Kind, mapping.Attribute, mapping.Type))
+	}
+
+	for _, value := range values {
+		if op == "matches" {
+			if _, err := regexp.Compile(fmt.Sprint(value)); err != nil {
+				problems = append(problems, fmt.Sprintf("value %v isn't a valid regular expression", formatValue(value)))
+			}
+		} else if !hasType(value, mapping.Type) {
+			problems = append(problems, fmt.Sprintf("value %v isn't a %v", formatValue(value), mapping.Type))
+		}
+	}
+	return problems
+}
+
+// Returns true if a clause value has the given attribute type. Dates are Unix milliseconds or RFC 3339 strings.
+func hasType(value interface{}, attributeType string) bool {
+	switch v := value.(type) {
+	case string:
+		switch attributeType {
+		case typeString:
+			return true
+		case typeSemver:
+			return semverValue.MatchString(v)
+		case typeDate:
+			_, err := time.Parse(time.RFC3339Nano, v)
+			return err == nil
+		}
+	case float64, int:
+		return attributeType == typeNumber || attributeType == typeDate
+	case bool:
+		return attributeType == typeBoolean
+	}
+	return false
+}
+
+// Helper function to get guardrail violations for the type problems of every clause and individual targets list that
+// the flag's migration rewrites. Values are transformed by the same helper as in the migration, but failures aren't
+// reported here.
+func typeMismatches(flag ldapi.FeatureFlag, details flagDetails) []string {
+	mismatches := []string{}
+	check := func(userAttribute string, op string, values []interface{}, mapping attributeSchema) {
+		for _, problem := range clauseTypeProblems(op, values, mapping) {
+			mismatches = append(mismatches, fmt.Sprintf("The migrated clause for user attribute '%v' doesn't suit its destination: %v.", userAttribute, problem))
+		}
+	}
+
+	// Individual targets only become clauses when the key maps to another attribute
+	for _, target := range details.targetUserRefs {
+		for _, destination := range splitValues(flag, keyAttribute, toInterfaces(target.target.Values)) {
+			if !sameAttribute(destination.mapping.Attribute, keyAttribute) {
+				values, _ := applyTransforms(keyAttribute, "in", destination.values, destination.mapping)
+				check(keyAttribute, "in", toDeclaredType(values, destination.mapping), destination.mapping)
+			}
+		}
+	}
+	for _, rule := range details.ruleUserRefs {
+		for _, clause := range rule.clauses {
+			if contains(attributesToIgnore, clause.Attribute) {
+				continue
+			}
+			for _, destination := range clauseDestinations(flag, clause) {
+				values, _ := applyTransforms(clause.Attribute, clause.Op, destination.values, destination.mapping)
+				check(clause.Attribute, clause.Op, values, destination.mapping)
+			}
+		}
+	}
+
+	return mismatches
+}
+
+// Report the type problems of a migrated clause
+func reportTypeProblems(userAttribute string, op string, values []interface{}, mapping attributeSchema) {
+	for _, problem := range clauseTypeProblems(op, values, mapping) {
+		fmt.Printf("  Warning: the migrated clause for user attribute '%v' doesn't suit its destination: %v.\n", userAttribute, problem)
+	}
+}
@@ -0,0 +1,132 @@
+package migrator
+
+import (
+	"testing"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+// Helper function to use a schema for the rest of a test
+func useSchema(t *testing.T, mappings map[string]attributeSchema) {
+	saved := schema
+	schema = mappings
+	t.Cleanup(func() { schema = saved })
+}
+
+func TestClauseTypeProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		op       string
+		values   []interface{}
+		declared string
+		problems int
+	}{
+		{"undeclared", "greaterThan", []interface{}{"a"}, "", 0},
+		{"numeric comparison", "greaterThan", []interface{}{float64(3)}, typeNumber, 0},
+		{"numeric comparison on a string", "greaterThan", []interface{}{float64(3)}, typeString, 1},
+		{"string value for a number", "in", []interface{}{"3", float64(4)}, typeNumber, 1},
+		{"semver", "semVerLessThan", []interface{}{"2.1", "x"}, typeSemver, 1},
+		{"date", "before", []interface{}{"2023-01-02T00:00:00Z", float64(1672617600000)}, typeDate, 0},
+		{"invalid regular expression", "matches", []interface{}{"("}, typeString, 1},
+		{"unchecked operator", "segmentMatch", []interface{}{"a"}, typeNumber, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping := attributeSchema{Kind: "device", Attribute: "version", Type: tt.declared}
+			if got := clauseTypeProblems(tt.op, tt.values, mapping); len(got) != tt.problems {
+				t.Errorf("clauseTypeProblems() = %v, want %v problem(s)", got, tt.problems)
+			}
+		})
+	}
+}
+
+func TestTypeMismatchesForTargetRules(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{
+		keyAttribute: {Kind: "location", Attribute: "zip", Type: typeNumber, Transforms: []valueTransform{{StripPrefix: "zip-"}}},
+	})
+
+	tests := []struct {
+		name       string
+		keys       []string
+		mismatches int
+	}{
+		{"numeric keys", []string{"zip-94107", "10001"}, 0},
+		{"non-numeric key", []string{"zip-94107", "unknown"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details := flagDetails{targetUserRefs: []targetInfo{{target: ldapi.Target{Values: tt.keys}}}}
+			if got := typeMismatches(ldapi.FeatureFlag{Key: "zip-flag"}, details); len(got) != tt.mismatches {
+				t.Errorf("typeMismatches() = %v, want %v mismatch(es)", got, tt.mismatches)
+			}
+
+			// The rule that replaces the targets must hold the same values that the guardrail checked
+			destination := splitValues(ldapi.FeatureFlag{Key: "zip-flag"}, keyAttribute, toInterfaces(tt.keys))[0]
+			values := toDeclaredType(transformValues(keyAttribute, "in", destination.values, destination.mapping), destination.mapping)
+			if got := clauseTypeProblems("in", values, destination.mapping); len(got) != tt.mismatches {
+				t.Errorf("clauseTypeProblems() for the migrated rule = %v, want %v problem(s)", got, tt.mismatches)
+			}
+		})
+	}
+}
+
+func TestTypeMismatchesForNegatedClauses(t *testing.T) {
+	// Negated clauses only move to the flag's mapping, so values that match the condition aren't checked against it
+	useSchema(t, map[string]attributeSchema{
+		"orgId": {Kind: "organization", Attribute: "key", Conditions: []conditionalMapping{
+			{ValuePattern: "^ws-", Kind: "workspace", Attribute: "size", Type: typeNumber},
+		}},
+	})
+	if err := validateCondition(&schema["orgId"].Conditions[0]); err != nil {
+		t.Fatalf("validateCondition() error = %v", err)
+	}
+
+	clause := ldapi.Clause{Attribute: "orgId", Op: "in", Values: []interface{}{"ws-1"}, ContextKind: strPtr(userKind)}
+	for _, negate := range []bool{false, true} {
+		clause.Negate = negate
+		details := flagDetails{ruleUserRefs: []ruleInfo{{clauses: []ldapi.Clause{clause}}}}
+		want := 1
+		if negate {
+			want = 0
+		}
+		if got := typeMismatches(ldapi.FeatureFlag{Key: "org-flag"}, details); len(got) != want {
+			t.Errorf("typeMismatches() with negate %v = %v, want %v mismatch(es)", negate, got, want)
+		}
+	}
+}
+
+func TestTypeMismatchesForClauses(t *testing.T) {
+	useSchema(t, map[string]attributeSchema{
+		"zip": {Kind: "location", Attribute: "zip", Type: typeNumber, Transforms: []valueTransform{{StripPrefix: "zip-"}, {Type: typeNumber}}},
+	})
+
+	tests := []struct {
+		name       string
+		op         string
+		values     []interface{}
+		mismatches int
+	}{
+		{"transformed values", "in", []interface{}{"zip-94107", "zip-10001"}, 0},
+		{"value that can't be transformed", "in", []interface{}{"zip-94107", "unknown"}, 1},
+		{"untransformed operator", "matches", []interface{}{"^zip-9"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clause := ldapi.Clause{Attribute: "zip", ContextKind: strPtr(userKind), Op: tt.op, Values: tt.values}
+			details := flagDetails{ruleUserRefs: []ruleInfo{{clauses: []ldapi.Clause{clause}}}}
+			if got := typeMismatches(ldapi.FeatureFlag{Key: "zip-flag"}, details); len(got) != tt.mismatches {
+				t.Errorf("typeMismatches() = %v, want %v mismatch(es)", got, tt.mismatches)
+			}
+
+			// The guardrail must check the same values that the migration writes
+			destination := clauseDestinations(ldapi.FeatureFlag{Key: "zip-flag"}, clause)[0]
+			migrated := destinationClause(clause, destination)
+			if got := clauseTypeProblems(tt.op, migrated["values"].([]interface{}), destination.mapping); len(got) != tt.mismatches {
+				t.Errorf("clauseTypeProblems() for the migrated clause = %v, want %v problem(s)", got, tt.mismatches)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package migrator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+const (
+	maxUsageValues   = 1000 // The most distinct values that are kept for each user attribute
+	maxReportSamples = 5    // The most sample values that the attributes report shows for each user attribute
+)
+
+// How a user attribute is referenced across the flags of a project
+type attributeUsage struct {
+	attribute string
+	flags     []string
+	targets   int
+	clauses   int
+	rollouts  int
+	operators []string
+	values    []interface{}
+	seen      map[string]bool
+}
+
+// Record a distinct value used with the attribute
+func (u *attributeUsage) addValue(value interface{}) {
+	str := fmt.Sprint(value)
+	if u.seen[str] || len(u.values) >= maxUsageValues {
+		return
+	}
+	u.seen[str] = true
+	u.values = append(u.values, value)
+}
+
+func (u *attributeUsage) addFlag(flag ldapi.FeatureFlag) {
+	if !contains(u.flags, flag.Key) {
+		u.flags = append(u.flags, flag.Key)
+	}
+}
+
+// Walk the individual targets, rule clauses and rollouts of each flag in an environment, and record the user
+// attributes they refer to. Attributes are keyed by their canonical form, so `/name` and `name` are counted together.
+func collectAttributeUsage(flags []ldapi.FeatureFlag, env string, usage map[string]*attributeUsage) {
+	get := func(attribute string) *attributeUsage {
+		key := canonicalAttribute(attribute)
+		if usage[key] == nil {
+			usage[key] = &attributeUsage{attribute: attribute, seen: map[string]bool{}}
+		}
+		return usage[key]
+	}
+	rollout := func(flag ldapi.FeatureFlag, rollout *ldapi.Rollout) {
+		if rollout == nil || (rollout.ContextKind != nil && *rollout.ContextKind != userKind) {
+			return
+		}
+		attribute := keyAttribute
+		if rollout.BucketBy != nil {
+			attribute = *rollout.BucketBy
+		}
+		u := get(attribute)
+		u.addFlag(flag)
+		u.rollouts++
+	}
+
+	for _, flag := range flags {
+		flagConfig, ok := flag.Environments[env]
+		if !ok {
+			continue
+		}
+
+		for _, target := range flagConfig.Targets {
+			if target.ContextKind != nil && *target.ContextKind != userKind {
+				continue
+			}
+			u := get(keyAttribute)
+			u.addFlag(flag)
+			u.targets++
+			for _, value := range target.Values {
+				u.addValue(value)
+			}
+		}
+
+		for _, rule := range flagConfig.Rules {
+			for _, clause := range rule.Clauses {
+				if !isUserClause(clause) || contains(attributesToIgnore, clause.Attribute) {
+					continue
+				}
+				u := get(clause.Attribute)
+				u.addFlag(flag)
+				u.clauses++
+				if !contains(u.operators, clause.Op) {
+					u.operators = append(u.operators, clause.Op)
+				}
+				for _, value := range clause.Values {
+					u.addValue(value)
+				}
+			}
+			rollout(flag, rule.Rollout)
+		}
+
+		if flagConfig.Fallthrough != nil {
+			rollout(flag, flagConfig.Fallthrough.Rollout)
+		}
+	}
+}
+
+// Helper function to get the canonical names of the recorded attributes in a stable order
+func sortedUsage(usage map[string]*attributeUsage) []string {
+	attributes := make([]string, 0, len(usage))
+	for attribute := range usage {
+		attributes = append(attributes, attribute)
+	}
+	sort.Strings(attributes)
+	return attributes
+}
+
+// AttributesReport lists every user attribute that the flags refer to in the report environments, with how often
+// and how it's used, and whether the schema maps it. Unmapped attributes are listed again at the end.
+func AttributesReport() {
+	Configure()
+	requireAPIKey()
+
+	usage := map[string]*attributeUsage{}
+	for _, env := range reportEnvKeys {
+		flags := []ldapi.FeatureFlag{}
+		for _, flag := range getFlags(env).Items {
+			if len(flagKeys) == 0 || contains(flagKeys, flag.Key) {
+				flags = append(flags, flag)
+			}
+		}
+		collectAttributeUsage(flags, env, usage)
+	}
+	fmt.Printf("User attributes referenced by flags in project '%v' and environment(s) '%v':\n", projectKey, strings.Join(reportEnvKeys, "', '"))
+
+	unmapped := []string{}
+	for _, attribute := range sortedUsage(usage) {
+		u := usage[attribute]
+		mapping, isMapped := lookupMapping(schema, u.attribute)
+
+		fmt.Println()
+		if isMapped {
+			fmt.Printf("'%v': mapped to '%v' attribute '%v'\n", u.attribute, mapping.Kind, mapping.Attribute)
+		} else {
+			fmt.Printf("'%v': UNMAPPED\n", u.attribute)
+			unmapped = append(unmapped, u.attribute)
+		}
+		if overrides := overridesForAttribute(u.attribute); overrides > 0 {
+			fmt.Printf("  Schema overrides: %v block(s) change this mapping for some environments or flags\n", overrides)
+		}
+		fmt.Printf("  Flags: %v (%v)\n", len(u.flags), strings.Join(u.flags, ", "))
+		fmt.Printf("  Individual target lists: %v, rule clauses: %v, rollouts: %v\n", u.targets, u.clauses, u.rollouts)
+		if len(u.operators) > 0 {
+			fmt.Printf("  Operators: %v\n", strings.Join(u.operators, ", "))
+		}
+		if len(u.values) > 0 {
+			samples := []string{}
+			for _, value := range u.values {
+				if len(samples) == maxReportSamples {
+					break
+				}
+				samples = append(samples, formatValue(value))
+			}
+			fmt.Printf("  Sample values: %v (%v distinct value(s))\n", strings.Join(samples, ", "), len(u.values))
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("%v user attribute(s) found.\n", len(usage))
+	fmt.Printf(" - %v user attribute(s) are mapped by the schema.\n", len(usage)-len(unmapped))
+	fmt.Printf(" - %v user attribute(s) aren't mapped by the schema", len(unmapped))
+	if len(unmapped) > 0 {
+		fmt.Printf(": %v", strings.Join(unmapped, ", "))
+	}
+	fmt.Println(".")
+}
+
+// Helper function to count the override blocks that replace or remove the mapping for a user attribute
+func overridesForAttribute(userAttribute string) int {
+	count := 0
+	for _, override := range schemaOverrides {
+		if _, isMapped := lookupMapping(override.Mappings, userAttribute); isMapped || containsAttribute(override.Remove, userAttribute) {
+			count++
+		}
+	}
+	return count
+}
+
+// Helper function to format a flag value for a report, quoting strings
+func formatValue(value interface{}) string {
+	if str, isStr := value.(string); isStr {
+		return fmt.Sprintf("%q", str)
+	}
+	return fmt.Sprint(value)
+}
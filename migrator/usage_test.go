@@ -0,0 +1,105 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+
+	ldapi "github.com/launchdarkly/api-client-go/v12"
+)
+
+func TestCollectAttributeUsage(t *testing.T) {
+	bucketBy := "/orgId"
+	config := ldapi.FeatureFlagConfig{
+		Targets:        []ldapi.Target{{Values: []string{"user-1", "user-2"}}},
+		ContextTargets: []ldapi.Target{{Values: []string{"account-1"}, ContextKind: strPtr("account")}},
+		Rules: []ldapi.Rule{
+			{
+				Clauses: []ldapi.Clause{
+					{Attribute: "orgId", ContextKind: strPtr(userKind), Op: "in", Values: []interface{}{"org-1", "org-2"}},
+					{Attribute: "/orgId", ContextKind: strPtr(userKind), Op: "startsWith", Values: []interface{}{"org-1"}},
+					{Attribute: "plan", ContextKind: strPtr("account"), Op: "in", Values: []interface{}{"pro"}},
+					{Attribute: "segmentMatch", Op: "segmentMatch", Values: []interface{}{"beta"}},
+				},
+				Rollout: &ldapi.Rollout{BucketBy: &bucketBy},
+			},
+		},
+		Fallthrough: &ldapi.VariationOrRolloutRep{Rollout: &ldapi.Rollout{ContextKind: strPtr("account")}},
+	}
+	other := ldapi.FeatureFlagConfig{
+		Targets:     []ldapi.Target{{Values: []string{"user-1"}}},
+		Fallthrough: &ldapi.VariationOrRolloutRep{Rollout: &ldapi.Rollout{}},
+	}
+	flags := []ldapi.FeatureFlag{
+		{Key: "a", Environments: map[string]ldapi.FeatureFlagConfig{"production": config}},
+		{Key: "b", Environments: map[string]ldapi.FeatureFlagConfig{"production": other, "staging": config}},
+	}
+
+	usage := map[string]*attributeUsage{}
+	collectAttributeUsage(flags, "production", usage)
+
+	if got, want := sortedUsage(usage), []string{"/key", "/orgId"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("collectAttributeUsage() attributes = %v, want %v", got, want)
+	}
+
+	key := usage["/key"]
+	if !reflect.DeepEqual(key.flags, []string{"a", "b"}) || key.targets != 2 || key.rollouts != 1 {
+		t.Errorf("key usage = %+v, want 2 flags, 2 target lists and 1 rollout", *key)
+	}
+	if !reflect.DeepEqual(key.values, []interface{}{"user-1", "user-2"}) {
+		t.Errorf("key values = %v, want the distinct target keys", key.values)
+	}
+
+	orgId := usage["/orgId"]
+	if orgId.clauses != 2 || orgId.rollouts != 1 || !reflect.DeepEqual(orgId.operators, []string{"in", "startsWith"}) {
+		t.Errorf("orgId usage = %+v, want 2 clauses, 1 rollout and operators in, startsWith", *orgId)
+	}
+	if !reflect.DeepEqual(orgId.values, []interface{}{"org-1", "org-2"}) {
+		t.Errorf("orgId values = %v, want the distinct clause values", orgId.values)
+	}
+}
+
+func TestOverridesForAttribute(t *testing.T) {
+	savedOverrides := schemaOverrides
+	t.Cleanup(func() { schemaOverrides = savedOverrides })
+	schemaOverrides = []schemaOverride{
+		{Flags: []string{"legacy-*"}, Remove: []string{"/email"}},
+		{Environments: []string{"staging"}, Mappings: map[string]attributeSchema{"email": {Kind: "member", Attribute: "email"}}},
+		{Environments: []string{"staging"}, Remove: []string{"plan"}},
+	}
+
+	tests := []struct {
+		attribute string
+		want      int
+	}{
+		{"email", 2},
+		{"plan", 1},
+		{"country", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.attribute, func(t *testing.T) {
+			if got := overridesForAttribute(tt.attribute); got != tt.want {
+				t.Errorf("overridesForAttribute() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{"pro", `"pro"`},
+		{float64(3), "3"},
+		{true, "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatValue(tt.value); got != tt.want {
+				t.Errorf("formatValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}